@@ -26,6 +26,8 @@ type Condition struct {
 	ActiveFor util.ConfigDuration `json:"active_for" yaml:"activeFor" default:"\"5s\""`
 	// Duration for which an active alert needs to be inactive to be resolved
 	ResolveIn util.ConfigDuration `json:"resolve_in,omitempty" yaml:"resolveIn,omitempty"`
+	// MinRequests is the amount of requests that are required to evaluate the condition
+	MinRequests int `json:"min_requests,omitempty" yaml:"minRequests,omitempty"`
 	// time the condition was first true
 	TriggerTime time.Time `json:"-" yaml:"-"`
 	// Condtional function to evaluate condition using backend metrics rates
@@ -81,8 +83,8 @@ allowed:
 	cond := new(Condition)
 	cond.Metric = metric
 	cond.Operator = operator
-	cond.ActiveFor = util.ConfigDuration{activeFor}
-	cond.ResolveIn = util.ConfigDuration{resolveIn}
+	cond.ActiveFor = util.ConfigDuration{Duration: activeFor}
+	cond.ResolveIn = util.ConfigDuration{Duration: resolveIn}
 	cond.Threshold = threshhold
 	cond.Compile()
 
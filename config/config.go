@@ -8,6 +8,7 @@ import (
 
 	"github.com/creasty/defaults"
 	"github.com/rgumi/depoy/gateway"
+	"github.com/rgumi/depoy/route"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/dealancer/validate.v2"
@@ -16,8 +17,9 @@ import (
 // UnmarshalFunc implements the UnmarshalFunc interface
 type UnmarshalFunc func(data []byte, v interface{}) error
 
-// ParseFromBinary uses the provided unmarshalFunc to create a new gateway object from b
-func ParseFromBinary(unmarshal UnmarshalFunc, b []byte) (*gateway.Gateway, error) {
+// ParseFromBinary uses the provided unmarshalFunc to create a new gateway object from b.
+// If the new gateway replaces current, redacted proxies are restored from its routes
+func ParseFromBinary(unmarshal UnmarshalFunc, b []byte, current *gateway.Gateway) (*gateway.Gateway, error) {
 	var err error
 	existingGateway := NewInputeGateway()
 	err = unmarshal(b, existingGateway)
@@ -31,6 +33,14 @@ func ParseFromBinary(unmarshal UnmarshalFunc, b []byte) (*gateway.Gateway, error
 			return nil, err
 		}
 
+		var currentRoute *route.Route
+		if current != nil {
+			currentRoute = current.Routes[existingRoute.Name]
+		}
+		if err := RestoreProxy(existingRoute, currentRoute); err != nil {
+			return nil, err
+		}
+
 		log.Infof("Adding existing route %v to  new Gateway", existingRoute.Name)
 		newRoute, err := ConvertInputRouteToRoute(existingRoute)
 		if err != nil {
@@ -62,7 +72,7 @@ func LoadFromFile(file string) *gateway.Gateway {
 	if err != nil {
 		log.Fatal(err)
 	}
-	g, err := ParseFromBinary(yaml.Unmarshal, b)
+	g, err := ParseFromBinary(yaml.Unmarshal, b, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -76,6 +86,8 @@ func WriteToFile(g *gateway.Gateway, file string) error {
 	i := 0
 	for _, r := range g.Routes {
 		outRoute := ConvertRouteToInputRoute(r)
+		// the credentials of the proxy are required after a restart
+		outRoute.Proxy = r.Proxy
 		out.Routes[i] = outRoute
 		i++
 	}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/creasty/defaults"
 	"github.com/google/uuid"
@@ -10,6 +12,7 @@ import (
 	"github.com/rgumi/depoy/metrics"
 	"github.com/rgumi/depoy/route"
 	"github.com/rgumi/depoy/storage"
+	"github.com/rgumi/depoy/upstreamclient"
 	"github.com/rgumi/depoy/util"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,12 +22,17 @@ type InputBackend struct {
 	Name             string                   `json:"name" yaml:"name" validate:"empty=false"`
 	Addr             string                   `json:"addr" yaml:"addr"`
 	Weigth           uint8                    `json:"weight" yaml:"weight"`
+	Priority         int                      `json:"priority" yaml:"priority"`
 	Active           bool                     `json:"active" yaml:"active"`
 	Scrapeurl        string                   `json:"scrape_url" yaml:"scrapeUrl"`
 	Scrapemetrics    []string                 `json:"scrape_metrics" yaml:"scrapeMetrics"`
 	Metricthresholds []*conditional.Condition `json:"metric_thresholds" yaml:"metricThresholds"`
 	Healthcheckurl   string                   `json:"healthcheck_url" yaml:"healthcheckUrl"`
 	ActiveAlerts     map[string]metrics.Alert `json:"active_alerts" yaml:"-"`
+	CircuitBreaker   *route.CircuitBreaker    `json:"circuit_breaker,omitempty" yaml:"circuitBreaker,omitempty"`
+	ConcurrencyLimit *route.ConcurrencyLimit  `json:"concurrency_limit,omitempty" yaml:"concurrencyLimit,omitempty"`
+	HeaderPolicy     *route.HeaderPolicy      `json:"header_policy,omitempty" yaml:"headerPolicy,omitempty"`
+	TLSPolicy        *route.TLSPolicy         `json:"tls_policy,omitempty" yaml:"tlsPolicy,omitempty"`
 }
 
 type InputGateway struct {
@@ -32,40 +40,66 @@ type InputGateway struct {
 	ReadTimeout  util.ConfigDuration `yaml:"read_timeout" json:"readTimeout" default:"\"5s\""`
 	WriteTimeout util.ConfigDuration `yaml:"write_timeout" json:"writeTimeout" default:"\"5s\""`
 	IdleTimeout  util.ConfigDuration `yaml:"idle_timeout" json:"idleTimeout" default:"\"10s\""`
-	Routes       []*InputRoute       `yaml:"routes" json:"routes"`
+	// ResponseHeaders are applied to every response of the gateway
+	ResponseHeaders *route.HeaderOperations `yaml:"response_headers,omitempty" json:"responseHeaders,omitempty"`
+	Routes          []*InputRoute           `yaml:"routes" json:"routes"`
 }
 
 type InputRoute struct {
-	Name                string              `json:"name" yaml:"name" validate:"empty=false"`
-	Prefix              string              `json:"prefix" yaml:"prefix" validate:"empty=false"`
-	Methods             []string            `json:"methods" yaml:"methods" default:"[\"GET\", \"POST\", \"PUT\", \"DELETE\", \"PATCH\", \"HEAD\", \"OPTIONS\", \"TRACE\"]"`
-	Host                string              `json:"host" yaml:"host" default:"*"`
-	Rewrite             string              `json:"rewrite" yaml:"rewrite" validate:"empty=false"`
-	CookieTTL           util.ConfigDuration `json:"cookie_ttl" yaml:"cookieTTL"`
-	Strategy            *route.Strategy     `json:"strategy" yaml:"strategy" validate:"nil=false"`
-	Switchover          *InputSwitchover    `json:"switchover" yaml:"-"`
-	HealthCheck         *bool               `json:"healthcheck_bool" yaml:"healthcheckBool"`
-	HealthCheckInterval util.ConfigDuration `json:"healthcheck_interval" yaml:"healthcheckInterval" default:"\"5s\""`
-	MonitoringInterval  util.ConfigDuration `json:"monitoring_interval" yaml:"monitoringInterval" default:"\"5s\""`
-	ReadTimeout         util.ConfigDuration `json:"read_timeout" yaml:"readTimeout" default:"\"5s\""`
-	WriteTimeout        util.ConfigDuration `json:"write_timeout" yaml:"writeTimeout" default:"\"5s\""`
-	IdleTimeout         util.ConfigDuration `json:"idle_timeout" yaml:"idleTimeout" default:"\"5s\""`
-	ScrapeInterval      util.ConfigDuration `json:"scrape_interval" yaml:"scrapeInterval" default:"\"5s\""`
-	Proxy               string              `json:"proxy" yaml:"proxy"`
-	Backends            []*InputBackend     `json:"backends" yaml:"backends"`
+	Name                string                    `json:"name" yaml:"name" validate:"empty=false"`
+	Prefix              string                    `json:"prefix" yaml:"prefix" validate:"empty=false"`
+	Match               string                    `json:"match" yaml:"match" default:"prefix"`
+	Methods             []string                  `json:"methods" yaml:"methods" default:"[\"GET\", \"POST\", \"PUT\", \"DELETE\", \"PATCH\", \"HEAD\", \"OPTIONS\", \"TRACE\"]"`
+	Host                string                    `json:"host" yaml:"host" default:"*"`
+	HostAliases         []string                  `json:"host_aliases,omitempty" yaml:"hostAliases,omitempty"`
+	Rewrite             string                    `json:"rewrite" yaml:"rewrite" validate:"empty=false"`
+	RewritePolicy       *route.RewritePolicy      `json:"rewrite_policy,omitempty" yaml:"rewritePolicy,omitempty"`
+	HeaderPolicy        *route.HeaderPolicy       `json:"header_policy,omitempty" yaml:"headerPolicy,omitempty"`
+	TLSPolicy           *route.TLSPolicy          `json:"tls_policy,omitempty" yaml:"tlsPolicy,omitempty"`
+	ClientCertPolicy    *route.ClientCertPolicy   `json:"client_cert_policy,omitempty" yaml:"clientCertPolicy,omitempty"`
+	CookieTTL           util.ConfigDuration       `json:"cookie_ttl" yaml:"cookieTTL"`
+	Strategy            *route.Strategy           `json:"strategy" yaml:"strategy" validate:"nil=false"`
+	Switchover          *InputSwitchover          `json:"switchover" yaml:"-"`
+	HealthCheck         *bool                     `json:"healthcheck_bool" yaml:"healthcheckBool"`
+	HealthCheckInterval util.ConfigDuration       `json:"healthcheck_interval" yaml:"healthcheckInterval" default:"\"5s\""`
+	MonitoringInterval  util.ConfigDuration       `json:"monitoring_interval" yaml:"monitoringInterval" default:"\"5s\""`
+	ReadTimeout         util.ConfigDuration       `json:"read_timeout" yaml:"readTimeout" default:"\"5s\""`
+	WriteTimeout        util.ConfigDuration       `json:"write_timeout" yaml:"writeTimeout" default:"\"5s\""`
+	IdleTimeout         util.ConfigDuration       `json:"idle_timeout" yaml:"idleTimeout" default:"\"5s\""`
+	ScrapeInterval      util.ConfigDuration       `json:"scrape_interval" yaml:"scrapeInterval" default:"\"5s\""`
+	Proxy               string                    `json:"proxy" yaml:"proxy"`
+	NoProxy             []string                  `json:"no_proxy,omitempty" yaml:"noProxy,omitempty"`
+	Retry               *route.RetryPolicy        `json:"retry,omitempty" yaml:"retry,omitempty"`
+	ConcurrencyLimit    *route.ConcurrencyLimit   `json:"concurrency_limit,omitempty" yaml:"concurrencyLimit,omitempty"`
+	RateLimit           *route.RateLimit          `json:"rate_limit,omitempty" yaml:"rateLimit,omitempty"`
+	Mirrors             []*route.Mirror           `json:"mirrors,omitempty" yaml:"mirrors,omitempty"`
+	TraceSampleRate     *float64                  `json:"trace_sample_rate,omitempty" yaml:"traceSampleRate,omitempty"`
+	AccessLogSampleRate *float64                  `json:"access_log_sample_rate,omitempty" yaml:"accessLogSampleRate,omitempty"`
+	FailoverThreshold   uint8                     `json:"failover_threshold" yaml:"failoverThreshold"`
+	DeploymentWindows   []*route.DeploymentWindow `json:"deployment_windows,omitempty" yaml:"deploymentWindows,omitempty"`
+	FreezePeriods       []*route.FreezePeriod     `json:"freeze_periods,omitempty" yaml:"freezePeriods,omitempty"`
+	Backends            []*InputBackend           `json:"backends" yaml:"backends"`
 }
 
 // InputSwitchover is required to add a switchover to a route
 // it is a wrapper for the actual SwitchOver struct and replaces
 // the actual backends (from and to) with their corrosponding ids
 type InputSwitchover struct {
-	Route        string                   `json:"route"`
-	Status       string                   `json:"status"`
-	From         string                   `json:"from"`
-	To           string                   `json:"to" validate:"empty=false"`
-	Conditions   []*conditional.Condition `json:"conditions" validate:"empty=false"`
-	Timeout      util.ConfigDuration      `json:"timeout" default:"\"2m\""`
-	WeightChange uint8                    `json:"weight_change" default:"5"`
+	Route      string                   `json:"route"`
+	Status     string                   `json:"status"`
+	From       string                   `json:"from"`
+	To         string                   `json:"to" validate:"empty=false"`
+	Conditions []*conditional.Condition `json:"conditions" validate:"empty=false"`
+	Timeout    util.ConfigDuration      `json:"timeout" default:"\"2m\""`
+	// BakePeriod after success in which alarming alerts of To rollback the switchover
+	BakePeriod util.ConfigDuration `json:"bake_period"`
+	BakeUntil  time.Time           `json:"bake_until,omitempty"`
+	// StartAt schedules the start of the switchover. If empty, it is started immediately
+	StartAt      time.Time `json:"start_at"`
+	WeightChange uint8     `json:"weight_change" default:"5"`
+	// Steps is a plan of weights the switchover progresses through. If empty, WeightChange is used
+	Steps       []*route.SwitchoverStep `json:"steps,omitempty"`
+	CurrentStep int                     `json:"current_step"`
 	// Force overwrites the current config of the backends to enable switchover (if required)
 	Force bool `json:"force,omitempty" default:"false"`
 	// If switchover fails, rollback all changes to the weights and stop switchover
@@ -73,6 +107,34 @@ type InputSwitchover struct {
 	// The amount of times a cycle is allowed to fail before switchover is stopped
 	AllowedFailures int `json:"allowed_failures" default:"5"`
 	FailureCounter  int `json:"failure_counter"`
+	// The amount of requests to To that are required to evaluate a cycle
+	MinRequests int `json:"min_requests"`
+	// The amount of consecutive cycles with too few requests after which
+	// InconclusiveAction (pause or fail) is applied
+	MaxInconclusive     int    `json:"max_inconclusive"`
+	InconclusiveAction  string `json:"inconclusive_action" default:"pause"`
+	InconclusiveCounter int    `json:"inconclusive_counter"`
+	// Transitions are the changes of the status of the switchover
+	Transitions []route.Transition `json:"transitions,omitempty"`
+	// Release which manages the switchover
+	Release string `json:"release,omitempty"`
+}
+
+// InputRelease is required to create a release which changes the weights
+// of the switchovers of multiple routes in lockstep
+type InputRelease struct {
+	Name            string                   `json:"name" validate:"empty=false"`
+	Status          string                   `json:"status"`
+	Members         []*route.ReleaseMember   `json:"members" validate:"empty=false"`
+	Conditions      []*conditional.Condition `json:"conditions" validate:"empty=false"`
+	Timeout         util.ConfigDuration      `json:"timeout" default:"\"2m\""`
+	WeightChange    uint8                    `json:"weight_change" default:"5"`
+	Force           bool                     `json:"force,omitempty" default:"false"`
+	Rollback        bool                     `json:"rollback,omitempty" default:"true"`
+	AllowedFailures int                      `json:"allowed_failures" default:"5"`
+	FailureCounter  int                      `json:"failure_counter"`
+	Transitions     []route.Transition       `json:"transitions,omitempty"`
+	Switchovers     []*InputSwitchover       `json:"switchovers,omitempty"`
 }
 
 func NewInputBackend() *InputBackend {
@@ -87,6 +149,12 @@ func NewInputSwitchover() *InputSwitchover {
 	return switchover
 }
 
+func NewInputRelease() *InputRelease {
+	release := new(InputRelease)
+	defaults.Set(release)
+	return release
+}
+
 func NewInputRoute() *InputRoute {
 	route := new(InputRoute)
 	defaults.Set(route)
@@ -107,12 +175,17 @@ func ConvertBackendToInputBackend(b *route.Backend) *InputBackend {
 		Name:             b.Name,
 		Addr:             b.Addr.String(),
 		Weigth:           b.Weigth,
+		Priority:         b.Priority,
 		Active:           b.Active,
 		Scrapeurl:        b.Scrapeurl.String(),
 		Scrapemetrics:    b.Scrapemetrics,
 		Metricthresholds: b.Metricthresholds,
 		Healthcheckurl:   b.Healthcheckurl.String(),
 		ActiveAlerts:     b.ActiveAlerts,
+		CircuitBreaker:   b.CircuitBreaker,
+		ConcurrencyLimit: b.ConcurrencyLimit,
+		HeaderPolicy:     b.HeaderPolicy,
+		TLSPolicy:        b.TLSPolicy,
 	}
 	return inputBackend
 }
@@ -143,28 +216,79 @@ func ConvertInputBackendToBackend(b *InputBackend) (*route.Backend, error) {
 		return nil, err
 	}
 	backend.ID = b.ID
+	backend.Priority = b.Priority
+	if b.CircuitBreaker != nil {
+		if err := defaults.Set(b.CircuitBreaker); err != nil {
+			return nil, err
+		}
+		backend.CircuitBreaker = b.CircuitBreaker
+	}
+	if b.ConcurrencyLimit != nil {
+		if err := defaults.Set(b.ConcurrencyLimit); err != nil {
+			return nil, err
+		}
+		if err := b.ConcurrencyLimit.Validate(); err != nil {
+			return nil, err
+		}
+		backend.ConcurrencyLimit = b.ConcurrencyLimit
+	}
+	backend.HeaderPolicy = b.HeaderPolicy
+	if b.TLSPolicy != nil {
+		if err := defaults.Set(b.TLSPolicy); err != nil {
+			return nil, err
+		}
+		backend.TLSPolicy = b.TLSPolicy
+	}
 	return backend, nil
 }
 
 // Route
 
+// RestoreProxy replaces the redacted proxy of the input with the proxy of the current
+// route. A proxy with a redacted password which cannot be restored is rejected
+func RestoreProxy(r *InputRoute, current *route.Route) error {
+	if current != nil && r.Proxy == upstreamclient.RedactProxy(current.Proxy) {
+		r.Proxy = current.Proxy
+	}
+	if upstreamclient.IsRedactedProxy(r.Proxy) {
+		return fmt.Errorf("Proxy of %s contains a redacted password", r.Name)
+	}
+	return nil
+}
+
 func ConvertRouteToInputRoute(r *route.Route) *InputRoute {
 	inputRoute := &InputRoute{
 		Name:                r.Name,
 		Prefix:              r.Prefix,
+		Match:               r.Match,
 		Rewrite:             r.Rewrite,
+		RewritePolicy:       r.RewritePolicy,
+		HeaderPolicy:        r.HeaderPolicy,
+		TLSPolicy:           r.TLSPolicy,
+		ClientCertPolicy:    r.ClientCertPolicy,
 		Strategy:            r.Strategy,
-		Proxy:               r.Proxy,
-		ReadTimeout:         util.ConfigDuration{r.ReadTimeout},
-		WriteTimeout:        util.ConfigDuration{r.WriteTimeout},
-		ScrapeInterval:      util.ConfigDuration{r.ScrapeInterval},
+		Proxy:               upstreamclient.RedactProxy(r.Proxy),
+		NoProxy:             r.NoProxy,
+		Retry:               r.Retry,
+		ConcurrencyLimit:    r.ConcurrencyLimit,
+		RateLimit:           r.RateLimit,
+		Mirrors:             r.Mirrors,
+		TraceSampleRate:     r.TraceSampleRate,
+		AccessLogSampleRate: r.AccessLogSampleRate,
+		FailoverThreshold:   r.FailoverThreshold,
+		DeploymentWindows:   r.DeploymentWindows,
+		FreezePeriods:       r.FreezePeriods,
+		ReadTimeout:         util.ConfigDuration{Duration: r.ReadTimeout},
+		WriteTimeout:        util.ConfigDuration{Duration: r.WriteTimeout},
+		ScrapeInterval:      util.ConfigDuration{Duration: r.ScrapeInterval},
 		Backends:            []*InputBackend{},
-		CookieTTL:           util.ConfigDuration{r.CookieTTL},
+		CookieTTL:           util.ConfigDuration{Duration: r.CookieTTL},
 		HealthCheck:         &r.HealthCheck,
-		HealthCheckInterval: util.ConfigDuration{r.HealthCheckInterval},
-		MonitoringInterval:  util.ConfigDuration{r.MonitoringInterval},
+		HealthCheckInterval: util.ConfigDuration{Duration: r.HealthCheckInterval},
+		MonitoringInterval:  util.ConfigDuration{Duration: r.MonitoringInterval},
 		Host:                r.Host,
-		IdleTimeout:         util.ConfigDuration{r.IdleTimeout},
+		HostAliases:         r.HostAliases,
+		IdleTimeout:         util.ConfigDuration{Duration: r.IdleTimeout},
 		Methods:             r.Methods,
 	}
 	inputRoute.Backends = make([]*InputBackend, len(r.Backends))
@@ -190,6 +314,7 @@ func ConvertInputRouteToRoute(r *InputRoute) (*route.Route, error) {
 	newRoute, err := route.New(
 		r.Name,
 		r.Prefix,
+		r.Match,
 		r.Rewrite,
 		r.Host,
 		r.Proxy,
@@ -203,6 +328,77 @@ func ConvertInputRouteToRoute(r *InputRoute) (*route.Route, error) {
 		r.CookieTTL.Duration,
 		hs,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if err := newRoute.SetFailoverThreshold(r.FailoverThreshold); err != nil {
+		return nil, err
+	}
+	newRoute.HostAliases = r.HostAliases
+	if err := newRoute.SetProxy(r.Proxy, r.NoProxy); err != nil {
+		return nil, err
+	}
+	newRoute.SetHeaderPolicy(r.HeaderPolicy)
+	if r.TLSPolicy != nil {
+		if err := defaults.Set(r.TLSPolicy); err != nil {
+			return nil, err
+		}
+		if err := newRoute.SetTLSPolicy(r.TLSPolicy); err != nil {
+			return nil, err
+		}
+	}
+	if err := newRoute.SetClientCertPolicy(r.ClientCertPolicy); err != nil {
+		return nil, err
+	}
+	if err := newRoute.SetTraceSampleRate(r.TraceSampleRate); err != nil {
+		return nil, err
+	}
+	if err := newRoute.SetAccessLogSampleRate(r.AccessLogSampleRate); err != nil {
+		return nil, err
+	}
+	if r.Retry != nil {
+		if err := defaults.Set(r.Retry); err != nil {
+			return nil, err
+		}
+		if err := newRoute.SetRetryPolicy(r.Retry); err != nil {
+			return nil, err
+		}
+	}
+	if r.ConcurrencyLimit != nil {
+		if err := defaults.Set(r.ConcurrencyLimit); err != nil {
+			return nil, err
+		}
+		if err := newRoute.SetConcurrencyLimit(r.ConcurrencyLimit); err != nil {
+			return nil, err
+		}
+	}
+	if r.RewritePolicy != nil {
+		if err := defaults.Set(r.RewritePolicy); err != nil {
+			return nil, err
+		}
+		if err := newRoute.SetRewritePolicy(r.RewritePolicy); err != nil {
+			return nil, err
+		}
+	}
+	if r.RateLimit != nil {
+		if err := defaults.Set(r.RateLimit); err != nil {
+			return nil, err
+		}
+		if err := newRoute.SetRateLimit(r.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+	for _, window := range r.DeploymentWindows {
+		if err := defaults.Set(window); err != nil {
+			return nil, err
+		}
+	}
+	if err := newRoute.SetDeploymentWindows(r.DeploymentWindows); err != nil {
+		return nil, err
+	}
+	if err := newRoute.SetFreezePeriods(r.FreezePeriods); err != nil {
+		return nil, err
+	}
 
 	for _, backend := range r.Backends {
 		if backend.ID == uuid.Nil {
@@ -222,6 +418,15 @@ func ConvertInputRouteToRoute(r *InputRoute) (*route.Route, error) {
 			return nil, err
 		}
 	}
+	// mirrors reference backends by name and are therefore set last
+	for _, mirror := range r.Mirrors {
+		if err := defaults.Set(mirror); err != nil {
+			return nil, err
+		}
+	}
+	if err := newRoute.SetMirrors(r.Mirrors); err != nil {
+		return nil, err
+	}
 	return newRoute, err
 }
 
@@ -239,15 +444,17 @@ func ConvertInputGatewayToGateway(g *InputGateway) *gateway.Gateway {
 		g.WriteTimeout.Duration,
 		g.IdleTimeout.Duration,
 	)
+	newGateway.ResponseHeaders = g.ResponseHeaders
 	return newGateway
 }
 func ConvertGatewayToInputGateway(g *gateway.Gateway) *InputGateway {
 	inputGateway := &InputGateway{
-		Addr:         g.Addr,
-		ReadTimeout:  util.ConfigDuration{g.ReadTimeout},
-		WriteTimeout: util.ConfigDuration{g.WriteTimeout},
-		IdleTimeout:  util.ConfigDuration{g.IdleTimeout},
-		Routes:       []*InputRoute{},
+		Addr:            g.Addr,
+		ReadTimeout:     util.ConfigDuration{Duration: g.ReadTimeout},
+		WriteTimeout:    util.ConfigDuration{Duration: g.WriteTimeout},
+		IdleTimeout:     util.ConfigDuration{Duration: g.IdleTimeout},
+		ResponseHeaders: g.ResponseHeaders,
+		Routes:          []*InputRoute{},
 	}
 	inputGateway.Routes = make([]*InputRoute, len(g.Routes))
 	i := 0
@@ -262,16 +469,56 @@ func ConvertGatewayToInputGateway(g *gateway.Gateway) *InputGateway {
 
 func ConvertSwitchoverToInputSwitchover(s *route.Switchover) *InputSwitchover {
 	inputRoute := &InputSwitchover{
-		Route:           s.Route.Name,
-		Status:          s.Status,
-		From:            s.From.Name,
-		To:              s.To.Name,
-		FailureCounter:  s.FailureCounter,
-		AllowedFailures: s.AllowedFailures,
-		WeightChange:    s.WeightChange,
-		Timeout:         util.ConfigDuration{s.Timeout},
-		Conditions:      s.Conditions,
-		Rollback:        s.Rollback,
+		Route:               s.Route.Name,
+		Status:              s.Status,
+		From:                s.From.Name,
+		To:                  s.To.Name,
+		FailureCounter:      s.FailureCounter,
+		AllowedFailures:     s.AllowedFailures,
+		WeightChange:        s.WeightChange,
+		Steps:               s.Steps,
+		CurrentStep:         s.CurrentStep,
+		Transitions:         s.Transitions,
+		Timeout:             util.ConfigDuration{Duration: s.Timeout},
+		BakePeriod:          util.ConfigDuration{Duration: s.BakePeriod},
+		BakeUntil:           s.BakeUntil,
+		StartAt:             s.StartAt,
+		MinRequests:         s.MinRequests,
+		MaxInconclusive:     s.MaxInconclusive,
+		InconclusiveAction:  s.InconclusiveAction,
+		InconclusiveCounter: s.InconclusiveCounter,
+		Conditions:          s.Conditions,
+		Rollback:            s.Rollback,
+		Release:             s.Release,
 	}
 	return inputRoute
 }
+
+// Release
+
+func ConvertReleaseToInputRelease(r *route.Release) *InputRelease {
+	inputRelease := &InputRelease{
+		Name:            r.Name,
+		Status:          r.Status,
+		Timeout:         util.ConfigDuration{Duration: r.Timeout},
+		WeightChange:    r.WeightChange,
+		Rollback:        r.Rollback,
+		AllowedFailures: r.AllowedFailures,
+		FailureCounter:  r.FailureCounter,
+		Transitions:     r.Transitions,
+		Members:         make([]*route.ReleaseMember, len(r.Switchovers)),
+		Switchovers:     make([]*InputSwitchover, len(r.Switchovers)),
+	}
+	for i, s := range r.Switchovers {
+		inputRelease.Members[i] = &route.ReleaseMember{
+			Route: s.Route.Name,
+			From:  s.From.Name,
+			To:    s.To.Name,
+		}
+		inputRelease.Switchovers[i] = ConvertSwitchoverToInputSwitchover(s)
+	}
+	if len(r.Switchovers) > 0 {
+		inputRelease.Conditions = r.Switchovers[0].Conditions
+	}
+	return inputRelease
+}
@@ -1,16 +1,21 @@
 package gateway
 
 import (
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/valyala/fasthttp/reuseport"
 
+	"github.com/rgumi/depoy/conditional"
 	"github.com/rgumi/depoy/metrics"
 	"github.com/rgumi/depoy/middleware"
 	"github.com/rgumi/depoy/route"
 	"github.com/rgumi/depoy/router"
+	"github.com/rgumi/depoy/tracing"
+	"github.com/rgumi/depoy/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 	"gopkg.in/yaml.v3"
@@ -18,8 +23,45 @@ import (
 
 var (
 	ServerName = "depoy/0.1.0"
+	// NoServerHeader disables the Server header of the gateway. Upstream
+	// Server headers can be removed using the HeaderPolicy of a route
+	NoServerHeader bool
+	// TLSCertFile and TLSKeyFile enable TLS on the listener of the gateway.
+	// The files are reloaded from disk when they change
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion is the minimal tls version of the listener (1.0, 1.1, 1.2 or 1.3)
+	TLSMinVersion string
 )
 
+func init() {
+	flag.BoolVar(&NoServerHeader, "gateway.noServerHeader", false, "defines if the Server header of the gateway is omitted")
+	flag.StringVar(&TLSCertFile, "gateway.tlsCert", "", "certificate of the gateway (enables tls)")
+	flag.StringVar(&TLSKeyFile, "gateway.tlsKey", "", "key of the certificate of the gateway")
+	flag.StringVar(&TLSMinVersion, "gateway.tlsMinVersion", "1.2", "minimal tls version of the gateway")
+}
+
+// tlsConfig returns the tls config of the listener or nil if tls is not enabled. Client
+// certificates are requested but only verified by the ClientCertPolicy of a route
+func tlsConfig() (*tls.Config, error) {
+	if TLSCertFile == "" && TLSKeyFile == "" {
+		return nil, nil
+	}
+	minVersion, err := util.TLSVersion(TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := util.NewCertReloader(TLSCertFile, TLSKeyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		ClientAuth:     tls.RequestClientCert,
+		MinVersion:     minVersion,
+	}, nil
+}
+
 //Gateway has a HTTP-Server which has Routes configured for it
 type Gateway struct {
 	Addr         string
@@ -27,10 +69,16 @@ type Gateway struct {
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 	Routes       map[string]*route.Route
+	Releases     map[string]*route.Release
 	Router       map[string]*router.Router
+	hosts        *hostTable
 	MetricsRepo  *metrics.Repository
 	server       *fasthttp.Server
 	mux          sync.Mutex
+
+	// ResponseHeaders are applied to every response of the gateway (including
+	// responses of unmatched hosts and paths) after the HeaderPolicy of the route
+	ResponseHeaders *route.HeaderOperations
 }
 
 //NewGateway returns a new instance of Gateway
@@ -46,12 +94,15 @@ func NewGateway(
 	g.Addr = addr
 	// initialize the map for storing the routes
 	g.Routes = make(map[string]*route.Route)
+	// initialize the map for storing the releases
+	g.Releases = make(map[string]*route.Release)
 
 	// map for each HOST
 	g.Router = make(map[string]*router.Router)
 
 	// any HOST router
 	g.Router["*"] = router.NewRouter()
+	g.hosts = newHostTable(g.Router)
 
 	// set timeouts
 	g.ReadTimeout = readTimeout
@@ -71,27 +122,47 @@ func (g *Gateway) Reload() {
 	// any host router
 	newRouter["*"] = router.NewRouter()
 	for _, routeItem := range g.Routes {
-		// Each host has its own router
-		if _, found := newRouter[routeItem.Host]; !found {
-			// host does not exist, create its router
-			newRouter[routeItem.Host] = router.NewRouter()
+		// Each host has its own router. Aliases share the handles of the route
+		for _, host := range routeItem.Hosts() {
+			host = NormalizeHost(host)
+			if _, found := newRouter[host]; !found {
+				// host does not exist, create its router
+				newRouter[host] = router.NewRouter()
+			}
+			// add all routes to the router
+			for _, method := range routeItem.Methods {
+				// for each http-method add a handler to the router
+				if err := newRouter[host].HandleMatch(method, routeItem.Match, routeItem.Prefix,
+					middleware.LogRequest(routeItem.GetHandler()),
+				); err != nil {
+					log.Errorf("Unable to add handle of %s: %v", routeItem.Name, err)
+				}
+			}
 		}
-		// add all routes to the router
-		for _, method := range routeItem.Methods {
-			// for each http-method add a handler to the router
-			newRouter[routeItem.Host].Handle(method, routeItem.Prefix,
-				middleware.LogRequest(routeItem.GetHandler()),
-			)
+		// the preview backend of a bluegreen strategy can have its own host and prefix
+		if host, prefix, ok := routeItem.PreviewHandle(); ok {
+			host = NormalizeHost(host)
+			if _, found := newRouter[host]; !found {
+				newRouter[host] = router.NewRouter()
+			}
+			for _, method := range routeItem.Methods {
+				if err := newRouter[host].Handle(method, prefix,
+					middleware.LogRequest(routeItem.GetPreviewHandler()),
+				); err != nil {
+					log.Errorf("Unable to add preview handle of %s: %v", routeItem.Name, err)
+				}
+			}
 		}
 	}
 	// overwrite existing tree with new
+	g.hosts = newHostTable(newRouter)
 	g.Router = newRouter
 }
 
 // Run starts the HTTP-Server of the Gateway
 func (g *Gateway) Run() {
 	g.server = &fasthttp.Server{
-		Handler:                       g.ServeHTTP,
+		Handler:                       middleware.RequestID(tracing.Middleware(g.ServeHTTP)),
 		Name:                          ServerName,
 		Concurrency:                   256 * 1024,
 		DisableKeepalive:              false,
@@ -102,7 +173,7 @@ func (g *Gateway) Run() {
 		MaxRequestsPerConn:            0,
 		TCPKeepalive:                  false,
 		DisableHeaderNamesNormalizing: false,
-		NoDefaultServerHeader:         false,
+		NoDefaultServerHeader:         NoServerHeader,
 	}
 
 	go func() {
@@ -111,6 +182,14 @@ func (g *Gateway) Run() {
 		if err != nil {
 			log.Fatalf("gateway reuseport listener failed with %v\n", err)
 		}
+		config, err := tlsConfig()
+		if err != nil {
+			log.Fatalf("gateway tls config is invalid: %v\n", err)
+		}
+		if config != nil {
+			log.Info("Gateway server terminates tls")
+			ln = tls.NewListener(ln, config)
+		}
 
 		if err := g.server.Serve(ln); err != nil {
 			log.Fatalf("gateway server listen failed with %v\n", err)
@@ -130,16 +209,33 @@ func (g *Gateway) checkIfExists(newRoute *route.Route) error {
 
 		// if name is not taken, check if other configs are taken
 		// if combination of prefix/host is already taken, return error
-		if route.Prefix == newRoute.Prefix && route.Host == newRoute.Host {
-			return fmt.Errorf(
-				"Route with combination of prefix (%s) and host (%s) already exist. Existing Route: %s",
-				route.Prefix, route.Host, routeName)
+		if route.Prefix != newRoute.Prefix || matchKeySpace(route.Match) != matchKeySpace(newRoute.Match) {
+			continue
+		}
+		for _, host := range route.Hosts() {
+			for _, newHost := range newRoute.Hosts() {
+				if NormalizeHost(host) == NormalizeHost(newHost) {
+					return fmt.Errorf(
+						"Route with combination of prefix (%s) and host (%s) already exist. Existing Route: %s",
+						route.Prefix, host, routeName)
+				}
+			}
 		}
 	}
 	// no error
 	return nil
 }
 
+// matchKeySpace returns the table of the router in which handles of the match are
+// stored. Prefix and segment handles share the radix tree and therefore conflict
+func matchKeySpace(match string) string {
+	switch match {
+	case "", router.MatchPrefix, router.MatchSegment:
+		return router.MatchPrefix
+	}
+	return match
+}
+
 // GetRoute returns the Route, if it exists. Otherwise nil
 func (g *Gateway) GetRoute(routeName string) *route.Route {
 	if route, found := g.Routes[routeName]; found {
@@ -161,6 +257,11 @@ func (g *Gateway) RegisterRoute(newRoute *route.Route) error {
 	if newRoute.Name == "" {
 		return fmt.Errorf("Route.Name cannot be empty")
 	}
+	for _, host := range newRoute.Hosts() {
+		if err = ValidateHost(NormalizeHost(host)); err != nil {
+			return err
+		}
+	}
 	if err = g.checkIfExists(newRoute); err != nil {
 		return err
 	}
@@ -169,6 +270,8 @@ func (g *Gateway) RegisterRoute(newRoute *route.Route) error {
 	}
 	log.Debugf("Setting up MetricsRepo for %s", newRoute.Name)
 	newRoute.MetricsRepo = g.MetricsRepo
+	// variants of a replaced route must not be mixed with the ones of the new strategy
+	g.MetricsRepo.ResetVariants(newRoute.Name)
 
 	g.Routes[newRoute.Name] = newRoute
 	log.Infof("Successfully registered new route %s", newRoute.Name)
@@ -198,15 +301,54 @@ func (g *Gateway) RemoveRoute(name string) *route.Route {
 	return nil
 }
 
+// StartRelease creates a release with a switchover for each member and starts it.
+// The name of a release must be unique among all active releases
+func (g *Gateway) StartRelease(
+	name string, members []*route.ReleaseMember,
+	conditions []*conditional.Condition,
+	timeout time.Duration, allowedFailures int,
+	weightChange uint8, force, rollback bool) (*route.Release, error) {
+
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if existing, found := g.Releases[name]; found && existing.IsActive() {
+		return nil, fmt.Errorf("Release with name %s is already active", name)
+	}
+	release, err := route.NewRelease(
+		name, g.Routes, members, conditions, timeout, allowedFailures, weightChange, force, rollback)
+	if err != nil {
+		return nil, err
+	}
+	g.Releases[name] = release
+	go release.Start()
+
+	log.Infof("Successfully started release %s", name)
+	return release, nil
+}
+
+// RemoveRelease aborts the release, if it is still running, and removes it
+func (g *Gateway) RemoveRelease(name, reason string) *route.Release {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if release, exists := g.Releases[name]; exists {
+		log.Warnf("Removing release %s from Gateway.Releases", name)
+		if release.IsActive() {
+			release.Abort(reason)
+		}
+		delete(g.Releases, name)
+		return release
+	}
+	return nil
+}
+
 // ServeHTTP is the required interface to quality as http.Handler
 // so the Gateway can be executed as a http.Server
 func (g *Gateway) ServeHTTP(ctx *fasthttp.RequestCtx) {
 	// error handling is done in router
-	if router, found := g.Router[string(ctx.Host())]; found {
-		router.ServeHTTP(ctx)
-		return
-	}
-	g.Router["*"].ServeHTTP(ctx)
+	g.hosts.lookup(string(ctx.Host())).ServeHTTP(ctx)
+	g.ResponseHeaders.ApplyToResponse(ctx)
 }
 
 // GetRoutes returns all Routes that are configured for the Gateway
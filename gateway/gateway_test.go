@@ -1 +1,85 @@
 package gateway
+
+import (
+	"testing"
+
+	"github.com/rgumi/depoy/route"
+	"github.com/rgumi/depoy/router"
+	"github.com/valyala/fasthttp"
+)
+
+func Test_NormalizeHost(t *testing.T) {
+	tests := map[string]string{
+		"Example.COM":          "example.com",
+		"example.com:8080":     "example.com",
+		"example.com.":         "example.com",
+		"[::1]:8080":           "[::1]",
+		"::1":                  "::1",
+		"~^Tenant-[0-9]+$":     "~^Tenant-[0-9]+$",
+		"*.Example.com:443":    "*.example.com",
+		" api.example.com:80 ": "api.example.com",
+	}
+	for host, expected := range tests {
+		if normalized := NormalizeHost(host); normalized != expected {
+			t.Errorf("Normalized %s to %s instead of %s", host, normalized, expected)
+		}
+	}
+}
+
+func Test_ValidateHost(t *testing.T) {
+	for _, host := range []string{"*", "example.com", "*.example.com", "~^a+\\.example\\.com$"} {
+		if err := ValidateHost(host); err != nil {
+			t.Errorf("Valid host %s was rejected: %v", host, err)
+		}
+	}
+	for _, host := range []string{"api.*.com", "*.", "*.*.example.com", "~(", "~"} {
+		if err := ValidateHost(host); err == nil {
+			t.Errorf("Invalid host %s was accepted", host)
+		}
+	}
+}
+
+func Test_HostPrecedence(t *testing.T) {
+	routers := map[string]*router.Router{
+		"*":                     router.NewRouter(),
+		"api.example.com":       router.NewRouter(),
+		"*.example.com":         router.NewRouter(),
+		"*.eu.example.com":      router.NewRouter(),
+		"~^tenant-[0-9]+\\.io$": router.NewRouter(),
+	}
+	hosts := newHostTable(routers)
+
+	tests := map[string]string{
+		"api.example.com":      "api.example.com",
+		"API.example.com:8080": "api.example.com",
+		"shop.example.com":     "*.example.com",
+		"a.b.example.com":      "*.example.com",
+		"shop.eu.example.com":  "*.eu.example.com",
+		"example.com":          "*",
+		"Tenant-42.io":         "~^tenant-[0-9]+\\.io$",
+		"tenant-x.io":          "*",
+		"":                     "*",
+	}
+	for host, expected := range tests {
+		if r := hosts.lookup(host); r != routers[expected] {
+			t.Errorf("Host %s did not resolve to the router of %s", host, expected)
+		}
+	}
+}
+
+func Test_ResponseHeaders(t *testing.T) {
+	g := NewGateway(":0", nil, 0, 0, 0)
+	g.ResponseHeaders = &route.HeaderOperations{
+		Set: map[string]string{"Strict-Transport-Security": "max-age=63072000"},
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://unknown.example.com/missing")
+	g.ServeHTTP(ctx)
+
+	if ctx.Response.StatusCode() != 404 {
+		t.Errorf("Expected status 404, got %d", ctx.Response.StatusCode())
+	}
+	if hsts := string(ctx.Response.Header.Peek("Strict-Transport-Security")); hsts != "max-age=63072000" {
+		t.Errorf("Expected response header of the gateway, got %q", hsts)
+	}
+}
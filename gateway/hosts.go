@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rgumi/depoy/router"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hosts of routes can be
+//   - exact (example.com)
+//   - wildcard (*.example.com), which matches any subdomain but not example.com itself
+//   - regex (~^tenant-[0-9]+\.example\.com$), which is matched case-insensitive
+//   - catch-all (*)
+// If multiple hosts match a request, exact is used before wildcard (longest first),
+// regex and catch-all
+
+// NormalizeHost removes the port and the trailing dot of the host and converts
+// it to lower case. Regex patterns are returned unchanged
+func NormalizeHost(host string) string {
+	if strings.HasPrefix(host, "~") {
+		return host
+	}
+	host = strings.ToLower(strings.TrimSpace(host))
+	if strings.HasPrefix(host, "[") {
+		// ipv6 address (e. g. [::1]:8080)
+		if end := strings.Index(host, "]"); end > 0 {
+			host = host[:end+1]
+		}
+	} else if i := strings.LastIndex(host, ":"); i >= 0 && strings.Count(host, ":") == 1 {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// ValidateHost checks if the host can be used as host of a route
+func ValidateHost(host string) error {
+	switch {
+	case host == "" || host == "*":
+		return nil
+	case strings.HasPrefix(host, "~"):
+		if _, err := regexp.Compile("(?i)" + host[1:]); err != nil || len(host) == 1 {
+			return fmt.Errorf("Invalid regular expression of host (%s)", host)
+		}
+	case strings.HasPrefix(host, "*."):
+		if strings.Contains(host[2:], "*") || len(host) == 2 {
+			return fmt.Errorf("Invalid wildcard host (%s)", host)
+		}
+	case strings.Contains(host, "*"):
+		return fmt.Errorf("Wildcards are only allowed as first label of a host (%s)", host)
+	}
+	return nil
+}
+
+type hostPattern struct {
+	pattern string
+	suffix  string
+	regex   *regexp.Regexp
+	router  *router.Router
+}
+
+// hostTable resolves the router of a request based on its host
+type hostTable struct {
+	exact     map[string]*router.Router
+	wildcards []*hostPattern
+	regexes   []*hostPattern
+	any       *router.Router
+}
+
+// newHostTable creates the lookup table for the routers. The keys of routers must be normalized
+func newHostTable(routers map[string]*router.Router) *hostTable {
+	t := &hostTable{
+		exact: make(map[string]*router.Router),
+		any:   routers["*"],
+	}
+	for host, r := range routers {
+		if err := ValidateHost(host); err != nil {
+			log.Errorf("Skipping router of host: %v", err)
+			continue
+		}
+		switch {
+		case host == "*":
+		case strings.HasPrefix(host, "~"):
+			t.regexes = append(t.regexes, &hostPattern{
+				pattern: host,
+				regex:   regexp.MustCompile("(?i)" + host[1:]),
+				router:  r,
+			})
+		case strings.HasPrefix(host, "*."):
+			t.wildcards = append(t.wildcards, &hostPattern{pattern: host, suffix: host[1:], router: r})
+		default:
+			t.exact[host] = r
+		}
+	}
+	// longest wildcard first, the order of regexes is only made deterministic
+	sort.Slice(t.wildcards, func(i, j int) bool {
+		if len(t.wildcards[i].suffix) != len(t.wildcards[j].suffix) {
+			return len(t.wildcards[i].suffix) > len(t.wildcards[j].suffix)
+		}
+		return t.wildcards[i].suffix < t.wildcards[j].suffix
+	})
+	sort.Slice(t.regexes, func(i, j int) bool {
+		return t.regexes[i].pattern < t.regexes[j].pattern
+	})
+	return t
+}
+
+// lookup returns the router of the host. If no host matches, the catch-all router is returned
+func (t *hostTable) lookup(rawHost string) *router.Router {
+	host := NormalizeHost(rawHost)
+	if r, found := t.exact[host]; found {
+		return r
+	}
+	for _, wildcard := range t.wildcards {
+		if len(host) > len(wildcard.suffix) && strings.HasSuffix(host, wildcard.suffix) {
+			return wildcard.router
+		}
+	}
+	for _, regex := range t.regexes {
+		if regex.regex.MatchString(host) {
+			return regex.router
+		}
+	}
+	return t.any
+}
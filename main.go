@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	_ "time/tzdata" // timezones of deployment windows
 
 	"github.com/rgumi/depoy/config"
 	"github.com/rgumi/depoy/gateway"
 	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/middleware"
+	"github.com/rgumi/depoy/route"
 	"github.com/rgumi/depoy/statemgt"
 	"github.com/rgumi/depoy/storage"
+	"github.com/rgumi/depoy/tracing"
 	log "github.com/sirupsen/logrus"
 
 	"net/http"
@@ -40,6 +45,18 @@ func main() {
 	flag.Parse()
 	// log.SetFormatter(&log.JSONFormatter{})
 	log.SetLevel(log.Level(config.LogLevel))
+	tracing.Init()
+	if err := middleware.InitAccessLog(); err != nil {
+		log.Fatal(err)
+	}
+	// the data of the gateway is stored next to the config file
+	dataDir := "data"
+	if config.ConfigFile != "" {
+		dataDir = filepath.Join(filepath.Dir(config.ConfigFile), "data")
+	}
+	if err := route.InitHistory(dataDir); err != nil {
+		log.Fatal(err)
+	}
 	// read config from file if configured
 	if config.ConfigFile != "" {
 		gw = config.LoadFromFile(config.ConfigFile)
@@ -88,4 +105,6 @@ func main() {
 	}
 	st.Stop()
 	st.Gateway.Stop()
+	tracing.Stop()
+	middleware.CloseAccessLog()
 }
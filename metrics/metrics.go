@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rgumi/depoy/conditional"
@@ -22,6 +23,9 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// HealthCheckAddr is the DownstreamAddr of the metrics of health checks
+const HealthCheckAddr = "depoy-healthcheck"
+
 var (
 
 	// DefaultMetrics are the default metrics that are offered
@@ -43,6 +47,8 @@ var (
 
 type Storage interface {
 	Write(string, uuid.UUID, map[string]float64, int64, int64, int)
+	WriteHealthCheck(string, uuid.UUID, int64, int64, int)
+	WriteRateLimited(string)
 	ReadData() map[string]map[uuid.UUID]map[time.Time]storage.Metric
 	ReadBackend(backend uuid.UUID, start, end time.Time) (storage.Metric, error)
 	ReadRoute(route string, start, end time.Time) (storage.Metric, error)
@@ -58,6 +64,8 @@ type Alert struct {
 	StartTime  time.Time
 	EndTime    time.Time
 	SendTime   time.Time
+	// RequestID of the last failed request of the backend when the alert was updated
+	RequestID string `json:"request_id,omitempty" yaml:"requestID,omitempty"`
 }
 
 type Metrics struct {
@@ -70,6 +78,10 @@ type Metrics struct {
 	UpstreamResponseTime int64
 	UpstreamRequestTime  int64
 	DownstreamAddr       string
+	Attempt              int
+	RateLimited          bool
+	Variant              string
+	RequestID            string
 }
 
 type ScrapeMetrics struct {
@@ -91,6 +103,13 @@ type MonitoredBackend struct {
 	ScrapeMetrics      []string
 	ScrapeInterval     time.Duration
 	ScrapeMetricPuffer map[string]float64
+	lastFailedRequest  atomic.Value // id of the last request which failed (status >= 500)
+}
+
+// lastFailedRequestID returns the id of the last request of the backend which failed
+func (b *MonitoredBackend) lastFailedRequestID() string {
+	id, _ := b.lastFailedRequest.Load().(string)
+	return id
 }
 
 type Repository struct {
@@ -102,6 +121,8 @@ type Repository struct {
 	client               *http.Client
 	scrapeMetricsChannel chan (ScrapeMetrics)
 	shutdown             chan int
+	variants             map[string]map[string]*VariantMetric
+	variantMux           sync.RWMutex
 }
 
 // NewMetricsRepository creates a new instance of NewMetricsRepository
@@ -122,6 +143,7 @@ func NewMetricsRepository(
 		Backends:             make(map[uuid.UUID]*MonitoredBackend),
 		shutdown:             make(chan int, 1), // Channel to kill Listen-Loop
 		scrapeMetricsChannel: scrapeMetricsChannel,
+		variants:             make(map[string]map[string]*VariantMetric),
 	}
 	go repo.Listen()
 
@@ -266,6 +288,7 @@ func (m *Repository) Monitor(backendID uuid.UUID, interval time.Duration) error
 							if now.After(alert.StartTime.Add(condition.GetActiveFor())) && alert.SendTime.IsZero() {
 								alert.Type = "Alarming"
 								alert.SendTime = now
+								alert.RequestID = backend.lastFailedRequestID()
 								backend.AlertChannel <- *alert
 							}
 							// goto next metric
@@ -298,6 +321,7 @@ func (m *Repository) Monitor(backendID uuid.UUID, interval time.Duration) error
 							Metric:     condition.Metric,
 							Threshhold: condition.Threshold,
 							Value:      collected[condition.Metric],
+							RequestID:  backend.lastFailedRequestID(),
 							StartTime:  now,
 						}
 						backend.activeAlerts[condition.Metric] = alert
@@ -321,15 +345,45 @@ func (m *Repository) Listen() {
 			return // stop listening
 		case metrics := <-m.InChannel:
 			log.Trace(metrics)
+			if metrics.RateLimited {
+				// rejected requests were never forwarded to a backend
+				RateLimitedRequests.With(prometheus.Labels{"route": metrics.Route}).Inc()
+				m.Storage.WriteRateLimited(metrics.Route)
+				ReleaseMetrics(metrics)
+				continue
+			}
 			// update PromMetrics
 			m.PromMetrics.Update(
 				float64(metrics.UpstreamResponseTime), float64(metrics.ContentLength),
 				metrics.ResponseStatus, metrics.RequestMethod, metrics.Route, metrics.BackendID)
+			if metrics.Attempt > 1 {
+				UpstreamRetries.With(
+					prometheus.Labels{
+						"route":   metrics.Route,
+						"backend": metrics.BackendID.String(),
+					},
+				).Inc()
+			}
+			if metrics.Variant != "" {
+				m.updateVariant(metrics)
+			}
 
 			backend, found := m.Backends[metrics.BackendID]
 			if !found { // check if backend exists (to avoid nil pointer exc)
 				continue
 			}
+			if metrics.ResponseStatus >= 500 && metrics.RequestID != "" {
+				backend.lastFailedRequest.Store(metrics.RequestID)
+			}
+			if metrics.DownstreamAddr == HealthCheckAddr {
+				// health checks are marked so that switchovers can exclude them
+				// from the requests of the clients
+				m.Storage.WriteHealthCheck(
+					metrics.Route, metrics.BackendID, metrics.UpstreamResponseTime,
+					metrics.ContentLength, metrics.ResponseStatus)
+				ReleaseMetrics(metrics)
+				continue
+			}
 			scrapeMetrics := backend.ScrapeMetricPuffer // Get Scrape Metrics for last interval
 			if scrapeMetrics == nil {
 				m.Storage.Write(
@@ -414,6 +468,8 @@ func (m *Repository) jobLoop(b *MonitoredBackend) {
 func (m *Repository) ReadRatesOfBackend(backend uuid.UUID, start, end time.Time) (map[string]float64, error) {
 	metricRates := make(map[string]float64)
 	current, err := m.Storage.ReadBackend(backend, start, end)
+	metricRates["TotalResponses"] = float64(current.TotalResponses)
+	metricRates["HealthChecks"] = float64(current.HealthChecks)
 
 	// there were no responses yet => avoid divison by 0
 	if current.TotalResponses == 0 {
@@ -627,5 +683,6 @@ func AcquireMetrics() *Metrics {
 	return MetricsPool.Get().(*Metrics)
 }
 func ReleaseMetrics(m *Metrics) {
+	*m = Metrics{}
 	MetricsPool.Put(m)
 }
@@ -69,6 +69,43 @@ var (
 		},
 		[]string{"route", "backend"},
 	)
+
+	// CircuitBreakerState is the state of the circuit breaker by route & backend
+	// 0 = closed, 1 = half-open, 2 = open
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ingress_depoy_circuit_breaker_state",
+			Help: "the state of the circuit breaker (0 = closed, 1 = half-open, 2 = open)",
+		},
+		[]string{"route", "backend"},
+	)
+
+	// RateLimitedRequests is the amount of requests that were rejected by the rate limit of a route
+	RateLimitedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingress_depoy_rate_limited_requests",
+			Help: "the amount of requests that were rejected due to rate limiting",
+		},
+		[]string{"route"},
+	)
+
+	// UpstreamRetries is the amount of retried requests by route & backend
+	UpstreamRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingress_depoy_upstream_retries",
+			Help: "the amount of requests that were retried",
+		},
+		[]string{"route", "backend"},
+	)
+
+	// VariantRequests is the amount of requests by route, variant of an experiment & status code
+	VariantRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingress_depoy_variant_requests",
+			Help: "the amount of requests that were forwarded to a variant of an experiment",
+		},
+		[]string{"route", "variant", "code"},
+	)
 )
 
 func init() {
@@ -76,6 +113,10 @@ func init() {
 	prometheus.MustRegister(AvgResponseTime)
 	prometheus.MustRegister(AvgContentLength)
 	prometheus.MustRegister(ActiveAlerts)
+	prometheus.MustRegister(CircuitBreakerState)
+	prometheus.MustRegister(UpstreamRetries)
+	prometheus.MustRegister(RateLimitedRequests)
+	prometheus.MustRegister(VariantRequests)
 }
 
 func (p *PromMetrics) GetCurrentMetrics() map[string]map[uuid.UUID]*PromMetric {
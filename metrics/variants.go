@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// VariantMetric stores the cumulative metrics of a variant of an experiment
+type VariantMetric struct {
+	TotalResponses    int64   `json:"total_responses"`
+	ResponseStatus200 int64   `json:"response_status_200"`
+	ResponseStatus300 int64   `json:"response_status_300"`
+	ResponseStatus400 int64   `json:"response_status_400"`
+	ResponseStatus500 int64   `json:"response_status_500"`
+	ResponseStatus600 int64   `json:"response_status_600"`
+	ResponseTime      float64 `json:"response_time"`
+	ContentLength     float64 `json:"content_length"`
+}
+
+// updateVariant adds the metrics of a request to the metrics of its variant
+func (m *Repository) updateVariant(metrics *Metrics) {
+	VariantRequests.With(
+		prometheus.Labels{
+			"route":   metrics.Route,
+			"variant": metrics.Variant,
+			"code":    strconv.Itoa(metrics.ResponseStatus)},
+	).Inc()
+
+	m.variantMux.Lock()
+	defer m.variantMux.Unlock()
+
+	if _, found := m.variants[metrics.Route]; !found {
+		m.variants[metrics.Route] = make(map[string]*VariantMetric)
+	}
+	variant, found := m.variants[metrics.Route][metrics.Variant]
+	if !found {
+		variant = new(VariantMetric)
+		m.variants[metrics.Route][metrics.Variant] = variant
+	}
+	n := float64(variant.TotalResponses)
+	variant.ResponseTime = (variant.ResponseTime*n + float64(metrics.UpstreamResponseTime)) / (n + 1)
+	variant.ContentLength = (variant.ContentLength*n + float64(metrics.ContentLength)) / (n + 1)
+	variant.TotalResponses++
+
+	switch status := metrics.ResponseStatus; {
+	case status < 300:
+		variant.ResponseStatus200++
+	case status < 400:
+		variant.ResponseStatus300++
+	case status < 500:
+		variant.ResponseStatus400++
+	case status < 600:
+		variant.ResponseStatus500++
+	default:
+		variant.ResponseStatus600++
+	}
+}
+
+// ReadVariants returns the metrics of all variants of the route. If
+// route is empty, the variants of all routes are returned
+func (m *Repository) ReadVariants(route string) map[string]map[string]VariantMetric {
+	m.variantMux.RLock()
+	defer m.variantMux.RUnlock()
+
+	variants := make(map[string]map[string]VariantMetric)
+	for routeName, variantsOfRoute := range m.variants {
+		if route != "" && routeName != route {
+			continue
+		}
+		variants[routeName] = make(map[string]VariantMetric, len(variantsOfRoute))
+		for name, variant := range variantsOfRoute {
+			variants[routeName][name] = *variant
+		}
+	}
+	return variants
+}
+
+// ResetVariants removes the metrics of all variants of the route
+func (m *Repository) ResetVariants(route string) {
+	m.variantMux.Lock()
+	defer m.variantMux.Unlock()
+	delete(m.variants, route)
+}
@@ -0,0 +1,353 @@
+package middleware
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// AccessLogJSON writes one JSON object per request
+	AccessLogJSON = "json"
+	// AccessLogCommon writes the Common Log Format
+	AccessLogCommon = "clf"
+	// AccessLogTemplate writes the AccessLogTemplateString
+	AccessLogTemplate = "template"
+
+	redacted = "[REDACTED]"
+)
+
+// templateField matches the fields of an AccessLogTemplateString
+var templateField = regexp.MustCompile(`\{(header\.[^{}]+|[a-z_]+)\}`)
+
+var (
+	// AccessLogOutput is stdout, stderr or the path of a file. If empty,
+	// requests are logged in the application log
+	AccessLogOutput string
+	// AccessLogFormat is the format of the access log. allowed: json, clf, template
+	AccessLogFormat string
+	// AccessLogTemplateString is used with the template format. Fields of the
+	// AccessLogEntry are referenced by their json name (e. g. {status}), the
+	// recorded headers by {header.<name>}
+	AccessLogTemplateString string
+	// AccessLogHeaders is a comma separated list of request headers which are recorded
+	AccessLogHeaders string
+	// AccessLogRedactHeaders is a comma separated list of headers whose values are redacted
+	AccessLogRedactHeaders string
+	// AccessLogRedactCookies is a comma separated list of cookies whose values
+	// are redacted in a recorded Cookie header. "*" redacts all cookies
+	AccessLogRedactCookies string
+	// AccessLogSampleRate is the ratio of requests which are logged if the route
+	// does not define it. Responses with a status >= 500 are always logged
+	AccessLogSampleRate float64
+	// AccessLogMaxSize is the size in MB after which the file is rotated
+	AccessLogMaxSize int
+	// AccessLogMaxAge is the age after which the file is rotated
+	AccessLogMaxAge time.Duration
+	// AccessLogMaxBackups is the amount of rotated files which are kept
+	AccessLogMaxBackups int
+
+	accessLog *AccessLogger
+	// key of the user value which holds the AccessLogEntry of the request
+	accessLogKey = "middleware.accessLog"
+)
+
+func init() {
+	flag.StringVar(&AccessLogOutput, "accesslog.output", "", "stdout, stderr or file of the access log (empty uses the application log)")
+	flag.StringVar(&AccessLogFormat, "accesslog.format", AccessLogJSON, "format of the access log (json, clf or template)")
+	flag.StringVar(&AccessLogTemplateString, "accesslog.template",
+		"{time} {remote_ip} \"{method} {uri} {proto}\" {status} {bytes} {duration_ms} {route} {backend} {request_id}",
+		"template of the access log")
+	flag.StringVar(&AccessLogHeaders, "accesslog.headers", "", "comma separated list of request headers which are recorded")
+	flag.StringVar(&AccessLogRedactHeaders, "accesslog.redactHeaders", "Authorization,Proxy-Authorization",
+		"comma separated list of headers whose values are redacted")
+	flag.StringVar(&AccessLogRedactCookies, "accesslog.redactCookies", "*",
+		"comma separated list of cookies whose values are redacted (* for all)")
+	flag.Float64Var(&AccessLogSampleRate, "accesslog.sampleRate", 1, "ratio of requests which are logged (0-1)")
+	flag.IntVar(&AccessLogMaxSize, "accesslog.maxSize", 100, "size in MB after which the access log file is rotated (0 disables)")
+	flag.DurationVar(&AccessLogMaxAge, "accesslog.maxAge", 24*time.Hour, "age after which the access log file is rotated (0 disables)")
+	flag.IntVar(&AccessLogMaxBackups, "accesslog.maxBackups", 7, "amount of rotated access log files which are kept (0 keeps all)")
+}
+
+// AccessLogEntry contains the fields of a request in the access log.
+// The handlers of the request can add their fields using AccessLogEntryOf
+type AccessLogEntry struct {
+	Time       string            `json:"time"`
+	RemoteIP   string            `json:"remote_ip"`
+	Method     string            `json:"method"`
+	Host       string            `json:"host"`
+	URI        string            `json:"uri"`
+	Proto      string            `json:"proto"`
+	Status     int               `json:"status"`
+	Bytes      int               `json:"bytes"`
+	Duration   float64           `json:"duration_ms"`
+	Upstream   float64           `json:"upstream_ms"`
+	Route      string            `json:"route,omitempty"`
+	Backend    string            `json:"backend,omitempty"`
+	BackendID  string            `json:"backend_id,omitempty"`
+	Attempts   int               `json:"attempts,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	UserAgent  string            `json:"user_agent"`
+	Referer    string            `json:"referer,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	SampleRate *float64          `json:"-"`
+	upstream   time.Duration
+}
+
+// AddUpstreamDuration adds the duration of an upstream request to the entry
+func (e *AccessLogEntry) AddUpstreamDuration(d time.Duration) {
+	e.upstream += d
+}
+
+// AccessLogEntryOf returns the entry of the request or nil if
+// the request is not recorded in the access log
+func AccessLogEntryOf(ctx *fasthttp.RequestCtx) *AccessLogEntry {
+	entry, _ := ctx.UserValue(accessLogKey).(*AccessLogEntry)
+	return entry
+}
+
+// AccessLogger writes the entries of the access log in its format to the writer
+type AccessLogger struct {
+	Format        string
+	Template      string
+	Headers       []string
+	RedactHeaders map[string]bool
+	RedactCookies map[string]bool
+	SampleRate    float64
+	out           io.Writer
+	failing       bool // the last write failed
+	mux           sync.Mutex
+}
+
+// NewAccessLogger returns a new AccessLogger which writes to out
+func NewAccessLogger(out io.Writer, format, template string) (*AccessLogger, error) {
+	format = strings.ToLower(format)
+	switch format {
+	case AccessLogJSON, AccessLogCommon, AccessLogTemplate:
+	default:
+		return nil, fmt.Errorf("Unsupported format of access log (%s)", format)
+	}
+	return &AccessLogger{
+		Format:        format,
+		Template:      template,
+		RedactHeaders: make(map[string]bool),
+		RedactCookies: make(map[string]bool),
+		SampleRate:    1,
+		out:           out,
+	}, nil
+}
+
+// InitAccessLog creates the access log using the flags. If AccessLogOutput
+// is empty, requests are logged in the application log
+func InitAccessLog() error {
+	if AccessLogOutput == "" {
+		return nil
+	}
+	var out io.Writer
+	switch AccessLogOutput {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		file, err := NewRotatingFile(AccessLogOutput,
+			int64(AccessLogMaxSize)*1024*1024, AccessLogMaxAge, AccessLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+	logger, err := NewAccessLogger(out, AccessLogFormat, AccessLogTemplateString)
+	if err != nil {
+		return err
+	}
+	logger.Headers = splitList(AccessLogHeaders)
+	for _, name := range splitList(AccessLogRedactHeaders) {
+		logger.RedactHeaders[strings.ToLower(name)] = true
+	}
+	for _, name := range splitList(AccessLogRedactCookies) {
+		logger.RedactCookies[name] = true
+	}
+	logger.SampleRate = AccessLogSampleRate
+	accessLog = logger
+	return nil
+}
+
+// CloseAccessLog closes the file of the access log
+func CloseAccessLog() {
+	if accessLog == nil {
+		return
+	}
+	if closer, ok := accessLog.out.(io.Closer); ok && accessLog.out != os.Stdout && accessLog.out != os.Stderr {
+		closer.Close()
+	}
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sampled returns if the request of the entry is logged
+func (l *AccessLogger) sampled(entry *AccessLogEntry) bool {
+	if entry.Status >= 500 {
+		return true
+	}
+	rate := l.SampleRate
+	if entry.SampleRate != nil {
+		rate = *entry.SampleRate
+	}
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// Log completes the entry with the request and response of ctx and writes it
+func (l *AccessLogger) Log(ctx *fasthttp.RequestCtx, entry *AccessLogEntry, start time.Time) {
+	entry.Status = ctx.Response.StatusCode()
+	if !l.sampled(entry) {
+		return
+	}
+	entry.Time = start.Format(time.RFC3339Nano)
+	entry.RemoteIP = ctx.RemoteIP().String()
+	entry.Method = string(ctx.Method())
+	entry.Host = string(ctx.Host())
+	entry.URI = string(ctx.RequestURI())
+	entry.Proto = "HTTP/1.0"
+	if ctx.Request.Header.IsHTTP11() {
+		entry.Proto = "HTTP/1.1"
+	}
+	entry.Bytes = len(ctx.Response.Body())
+	entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+	entry.Upstream = float64(entry.upstream.Microseconds()) / 1000
+	entry.RequestID = GetRequestID(ctx)
+	entry.UserAgent = string(ctx.Request.Header.UserAgent())
+	entry.Referer = string(ctx.Request.Header.Referer())
+	if len(l.Headers) > 0 {
+		entry.Headers = make(map[string]string, len(l.Headers))
+		for _, name := range l.Headers {
+			if value := ctx.Request.Header.Peek(name); len(value) > 0 {
+				entry.Headers[strings.ToLower(name)] = l.redact(name, string(value))
+			}
+		}
+	}
+
+	line, err := l.format(entry)
+	if err != nil {
+		log.Debugf("Unable to format access log entry: %v", err)
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, err = l.out.Write(append(line, '\n')); err != nil {
+		// reported once until writing succeeds again
+		if !l.failing {
+			log.Errorf("Unable to write access log: %v", err)
+		}
+		l.failing = true
+		return
+	}
+	if l.failing {
+		log.Info("Writing access log succeeded again")
+		l.failing = false
+	}
+}
+
+// redact replaces the value of the header or of the cookies in the Cookie header
+func (l *AccessLogger) redact(name, value string) string {
+	if l.RedactHeaders[strings.ToLower(name)] {
+		return redacted
+	}
+	if !strings.EqualFold(name, "Cookie") || len(l.RedactCookies) == 0 {
+		return value
+	}
+	cookies := strings.Split(value, ";")
+	for i, cookie := range cookies {
+		cookie = strings.TrimSpace(cookie)
+		if idx := strings.IndexByte(cookie, '='); idx > 0 {
+			if l.RedactCookies["*"] || l.RedactCookies[cookie[:idx]] {
+				cookie = cookie[:idx+1] + redacted
+			}
+		}
+		cookies[i] = cookie
+	}
+	return strings.Join(cookies, "; ")
+}
+
+func (l *AccessLogger) format(entry *AccessLogEntry) ([]byte, error) {
+	switch l.Format {
+	case AccessLogCommon:
+		return []byte(formatCommon(entry)), nil
+	case AccessLogTemplate:
+		return []byte(formatTemplate(l.Template, entry)), nil
+	}
+	return json.Marshal(entry)
+}
+
+// formatCommon returns the entry in the Common Log Format
+func formatCommon(entry *AccessLogEntry) string {
+	t, _ := time.Parse(time.RFC3339Nano, entry.Time)
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.Itoa(entry.Bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		entry.RemoteIP, t.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, entry.URI, entry.Proto, entry.Status, bytes,
+	)
+}
+
+// formatTemplate replaces the fields in the template. Empty fields and headers which
+// were not sent are replaced with "-". Header names are matched case-insensitively
+func formatTemplate(template string, entry *AccessLogEntry) string {
+	value := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+	fields := map[string]string{
+		"{time}":        value(entry.Time),
+		"{remote_ip}":   value(entry.RemoteIP),
+		"{method}":      value(entry.Method),
+		"{host}":        value(entry.Host),
+		"{uri}":         value(entry.URI),
+		"{proto}":       value(entry.Proto),
+		"{status}":      strconv.Itoa(entry.Status),
+		"{bytes}":       strconv.Itoa(entry.Bytes),
+		"{duration_ms}": strconv.FormatFloat(entry.Duration, 'f', 3, 64),
+		"{upstream_ms}": strconv.FormatFloat(entry.Upstream, 'f', 3, 64),
+		"{route}":       value(entry.Route),
+		"{backend}":     value(entry.Backend),
+		"{backend_id}":  value(entry.BackendID),
+		"{attempts}":    strconv.Itoa(entry.Attempts),
+		"{request_id}":  value(entry.RequestID),
+		"{user_agent}":  value(entry.UserAgent),
+		"{referer}":     value(entry.Referer),
+	}
+	// the fields are only searched in the template, never in the values of the request
+	return templateField.ReplaceAllStringFunc(template, func(field string) string {
+		if strings.HasPrefix(field, "{header.") {
+			name := field[len("{header.") : len(field)-1]
+			return value(entry.Headers[strings.ToLower(name)])
+		}
+		if v, found := fields[field]; found {
+			return v
+		}
+		return field
+	})
+}
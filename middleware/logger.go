@@ -8,15 +8,28 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// LogRequest records the request in the access log. If no access log
+// is configured, the request is logged in the application log
 func LogRequest(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		before := time.Now()
+		if logger := accessLog; logger != nil {
+			entry := &AccessLogEntry{}
+			ctx.SetUserValue(accessLogKey, entry)
+			defer logger.Log(ctx, entry, before)
+			handler(ctx)
+			return
+		}
 
 		defer func() {
-			log.Infof("%s \"%s %s %s\" %d %d %v",
+			requestID := GetRequestID(ctx)
+			if requestID == "" {
+				requestID = "-"
+			}
+			log.Infof("%s \"%s %s %s\" %d %d %v %s",
 				ctx.RemoteAddr(), ctx.Method(), ctx.URI().String(),
 				string(ctx.Request.Header.UserAgent()), ctx.Response.StatusCode(),
-				ctx.Response.Header.ContentLength(), time.Since(before),
+				ctx.Response.Header.ContentLength(), time.Since(before), requestID,
 			)
 		}()
 		handler(ctx)
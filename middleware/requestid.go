@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// RequestIDHeader is the header which contains the id of a request
+	RequestIDHeader string
+	// RequestIDFormat is the format of generated ids. allowed: uuid, ulid
+	RequestIDFormat string
+	// TrustRequestID defines if the id of the downstream request is used
+	TrustRequestID bool
+
+	// maximal length of an id that is accepted from the downstream
+	maxRequestIDLength = 128
+	// key of the user value which holds the id of the request
+	requestIDKey = "middleware.requestID"
+	// alphabet of ulids (Crockford's base32)
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+func init() {
+	flag.StringVar(&RequestIDHeader, "requestid.header", "X-Request-ID", "header which contains the id of a request")
+	flag.StringVar(&RequestIDFormat, "requestid.format", "uuid", "format of generated request ids (uuid or ulid)")
+	flag.BoolVar(&TrustRequestID, "requestid.trust", true, "defines if the request id of the downstream is accepted")
+}
+
+// RequestID accepts the id of the downstream request or generates a new one. The id
+// is forwarded to the upstream in the RequestIDHeader and returned in the response
+func RequestID(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := ""
+		if TrustRequestID {
+			id = validRequestID(ctx.Request.Header.Peek(RequestIDHeader))
+		}
+		if id == "" {
+			id = NewRequestID()
+		}
+		ctx.Request.Header.Set(RequestIDHeader, id)
+		ctx.SetUserValue(requestIDKey, id)
+
+		handler(ctx)
+		ctx.Response.Header.Set(RequestIDHeader, id)
+	}
+}
+
+// GetRequestID returns the id of the request. If the request
+// was not handled by the RequestID middleware, an empty string is returned
+func GetRequestID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue(requestIDKey).(string)
+	return id
+}
+
+// Error writes the error response like ctx.Error and adds the id of the request to the body
+func Error(ctx *fasthttp.RequestCtx, msg string, statusCode int) {
+	if id := GetRequestID(ctx); id != "" {
+		msg = fmt.Sprintf("%s (request id: %s)", msg, id)
+	}
+	ctx.Error(msg, statusCode)
+}
+
+// NewRequestID generates a new id using the RequestIDFormat
+func NewRequestID() string {
+	if strings.ToLower(RequestIDFormat) == "ulid" {
+		return newULID(time.Now())
+	}
+	return uuid.New().String()
+}
+
+// validRequestID returns the id if it can safely be logged and forwarded
+func validRequestID(id []byte) string {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return ""
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return ""
+		}
+	}
+	return string(id)
+}
+
+// newULID returns a ulid which consists of 48 bits of the timestamp in
+// milliseconds and 80 random bits encoded in 26 characters
+func newULID(t time.Time) string {
+	var data [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(data[:8], ms<<16)
+	if _, err := rand.Read(data[6:]); err != nil {
+		return uuid.New().String()
+	}
+
+	id := make([]byte, 26)
+	// 128 bits are encoded in 5 bit groups, the first character only holds 3 bits
+	hi := binary.BigEndian.Uint64(data[:8])
+	lo := binary.BigEndian.Uint64(data[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id)
+}
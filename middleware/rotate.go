@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RotatingFile is a file which is rotated when it exceeds MaxSize bytes or
+// is older than MaxAge. Rotated files are renamed to <name>.<timestamp> and only
+// the latest MaxBackups are kept. A value of 0 disables the respective limit
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+	closed     bool
+	mux        sync.Mutex
+}
+
+// NewRotatingFile opens the file and returns the RotatingFile
+func NewRotatingFile(filename string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// Write writes p to the file and rotates it beforehand if required
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.file == nil {
+		if f.closed {
+			return 0, fmt.Errorf("File %s is closed", f.Filename)
+		}
+		// a previous rotation failed
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if (f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize) ||
+		(f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge) {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			// the current file is kept until the next rotation
+			log.Errorf("Unable to rotate %s: %v", f.Filename, err)
+			f.opened = time.Now()
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and opens a new one. If the rotation fails,
+// the original file is opened again. The caller must hold the lock
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		f.open()
+		return err
+	}
+	backup := f.Filename + "." + time.Now().Format("20060102T150405.000")
+	if err := os.Rename(f.Filename, backup); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		if os.Rename(backup, f.Filename) == nil {
+			f.open()
+		}
+		return err
+	}
+	f.removeBackups()
+	return nil
+}
+
+// removeBackups deletes the oldest rotated files above MaxBackups
+func (f *RotatingFile) removeBackups() {
+	if f.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.Filename + ".*")
+	if err != nil {
+		return
+	}
+	prefix := f.Filename + "."
+	filtered := backups[:0]
+	for _, backup := range backups {
+		if len(strings.TrimPrefix(backup, prefix)) == len("20060102T150405.000") {
+			filtered = append(filtered, backup)
+		}
+	}
+	// the timestamps sort chronologically
+	sort.Strings(filtered)
+	for i := 0; i < len(filtered)-f.MaxBackups; i++ {
+		os.Remove(filtered[i])
+	}
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,134 @@
+package route
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// DefaultVariantHeader is the header which contains the variant of an abtest for the backend
+	DefaultVariantHeader = "X-Depoy-Variant"
+)
+
+// Variant is a named variant of an abtest. Percentage of the users
+// are assigned to the variant and forwarded to its backend
+type Variant struct {
+	Name       string `json:"name" yaml:"name"`
+	Backend    string `json:"backend" yaml:"backend"`
+	Percentage uint8  `json:"percentage" yaml:"percentage"`
+	backend    *Backend
+}
+
+// validateVariants checks the variants and the bucketing of an abtest
+func validateVariants(variants []*Variant, bucketBy, bucketKey string) error {
+	if len(variants) == 0 {
+		return fmt.Errorf("Required parameter are missing")
+	}
+	switch strings.ToLower(bucketBy) {
+	case "header", "cookie":
+		if bucketKey == "" {
+			return fmt.Errorf("Bucketing by %s requires a bucket key", bucketBy)
+		}
+	case "jwt":
+	default:
+		return fmt.Errorf("Unsupported bucketing (%s)", bucketBy)
+	}
+
+	var sum int
+	names := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if variant.Name == "" || variant.Backend == "" {
+			return fmt.Errorf("Name and Backend of variant are required")
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("Variant %s is defined more than once", variant.Name)
+		}
+		names[variant.Name] = true
+		sum += int(variant.Percentage)
+	}
+	if sum != 100 {
+		return fmt.Errorf("Percentages of variants must add up to 100 not %d", sum)
+	}
+	return nil
+}
+
+// selectVariant assigns the identifier to a variant. The same identifier
+// is always assigned to the same variant as long as the variants are unchanged
+func selectVariant(routeName, identifier string, variants []*Variant) *Variant {
+	h := fnv.New32a()
+	h.Write([]byte(routeName))
+	h.Write([]byte(identifier))
+	bucket := int(h.Sum32() % 100)
+
+	var upper int
+	for _, variant := range variants {
+		upper += int(variant.Percentage)
+		if bucket < upper {
+			return variant
+		}
+	}
+	return variants[len(variants)-1]
+}
+
+// bucketIdentifier returns the stable identifier of the user. If
+// it cannot be found, the ip of the client is used
+func bucketIdentifier(ctx *fasthttp.RequestCtx, bucketBy, bucketKey string) string {
+	var identifier []byte
+	switch strings.ToLower(bucketBy) {
+	case "header":
+		identifier = ctx.Request.Header.Peek(bucketKey)
+	case "cookie":
+		identifier = ctx.Request.Header.Cookie(bucketKey)
+	case "jwt":
+		if bucketKey == "" {
+			bucketKey = "Authorization"
+		}
+		identifier = jwtSubject(ctx.Request.Header.Peek(bucketKey))
+	}
+	if len(identifier) == 0 {
+		return ctx.RemoteIP().String()
+	}
+	return string(identifier)
+}
+
+// jwtSubject returns the subject of a bearer token. The token is not verified
+// as it is only used to identify the user, not to authenticate it
+func jwtSubject(value []byte) []byte {
+	token := bytes.TrimSpace(value)
+	if len(token) > 7 && bytes.EqualFold(token[:7], []byte("Bearer ")) {
+		token = token[7:]
+	}
+	parts := bytes.Split(token, []byte("."))
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(parts[1]), "="))
+	if err != nil {
+		return nil
+	}
+	claims := struct {
+		Subject string `json:"sub"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return []byte(claims.Subject)
+}
+
+// variantOf returns the variant of the request if the route uses an abtest
+func (r *Route) variantOf(req *fasthttp.Request) string {
+	r.mux.RLock()
+	strategy := r.Strategy
+	r.mux.RUnlock()
+
+	if strategy == nil || strategy.VariantHeader == "" {
+		return ""
+	}
+	return string(req.Header.Peek(strategy.VariantHeader))
+}
@@ -0,0 +1,39 @@
+package route
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rgumi/depoy/middleware"
+	"github.com/valyala/fasthttp"
+)
+
+// SetAccessLogSampleRate sets the ratio of the requests of the route which are
+// written to the access log. If rate is nil, the global sample rate is used
+func (r *Route) SetAccessLogSampleRate(rate *float64) error {
+	if rate != nil && (*rate < 0 || *rate > 1) {
+		return fmt.Errorf("AccessLogSampleRate must be between 0 and 1")
+	}
+	r.mux.Lock()
+	r.AccessLogSampleRate = rate
+	r.mux.Unlock()
+	return nil
+}
+
+// logRoute adds the route to the access log entry of the request
+func (r *Route) logRoute(ctx *fasthttp.RequestCtx, sampleRate *float64) {
+	if entry := middleware.AccessLogEntryOf(ctx); entry != nil {
+		entry.Route = r.Name
+		entry.SampleRate = sampleRate
+	}
+}
+
+// logAttempt adds the backend and the duration of an attempt to the access log entry
+func logAttempt(ctx *fasthttp.RequestCtx, target *Backend, attempt int, latency time.Duration) {
+	if entry := middleware.AccessLogEntryOf(ctx); entry != nil {
+		entry.Backend = target.Name
+		entry.BackendID = target.ID.String()
+		entry.Attempts = attempt
+		entry.AddUpstreamDuration(latency)
+	}
+}
@@ -19,14 +19,20 @@ type Backend struct {
 	Name             string                   `json:"name" yaml:"name" validate:"empty=false"`
 	Addr             *url.URL                 `json:"addr" yaml:"addr"`
 	Weigth           uint8                    `json:"weight" yaml:"weight"`
+	Priority         int                      `json:"priority" yaml:"priority"`
 	Active           bool                     `json:"active" yaml:"active"`
 	Scrapeurl        *url.URL                 `json:"scrape_url" yaml:"scrapeUrl"`
 	Scrapemetrics    []string                 `json:"scrape_metrics" yaml:"scrapeMetrics"`
 	Metricthresholds []*conditional.Condition `json:"metric_thresholds" yaml:"metricThresholds"`
 	Healthcheckurl   *url.URL                 `json:"healthcheck_url" yaml:"healthcheckUrl"`
 	ActiveAlerts     map[string]metrics.Alert `json:"active_alerts" yaml:"-"`
+	CircuitBreaker   *CircuitBreaker          `json:"circuit_breaker,omitempty" yaml:"circuitBreaker,omitempty"`
+	ConcurrencyLimit *ConcurrencyLimit        `json:"concurrency_limit,omitempty" yaml:"concurrencyLimit,omitempty"`
+	HeaderPolicy     *HeaderPolicy            `json:"header_policy,omitempty" yaml:"headerPolicy,omitempty"`
+	TLSPolicy        *TLSPolicy               `json:"tls_policy,omitempty" yaml:"tlsPolicy,omitempty"`
 	AlertChan        <-chan metrics.Alert     `json:"-" yaml:"-"`
 	updateWeigth     func()
+	onAlarm          func(*Backend, metrics.Alert)
 	mux              sync.Mutex
 	killChan         chan int
 }
@@ -116,9 +122,14 @@ func (b *Backend) Monitor() {
 		case alert := <-b.AlertChan:
 			log.Debugf("Backend %v received %v", b.ID, alert.Type)
 			if alert.Type == "Alarming" {
+				log.Warnf("Alert (%s) of backend %v is alarming. Last failed request: %s",
+					alert.Metric, b.ID, alert.RequestID)
 				// Alarm condition was active for long enought => alarming
 				b.ActiveAlerts[alert.Metric] = alert
 				b.UpdateStatus(false)
+				if b.onAlarm != nil {
+					b.onAlarm(b, alert)
+				}
 			} else if alert.Type == "Pending" {
 				// Alarm condition was reached initially
 				b.ActiveAlerts[alert.Metric] = alert
@@ -0,0 +1,128 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+// FlipBlueGreen swaps the active and the preview backend of a bluegreen strategy.
+// If FlipBackAfter is configured, an alarm of the new active backend within this
+// duration flips back
+func (r *Route) FlipBlueGreen(reason string) (*Strategy, error) {
+	return r.flip(reason, true)
+}
+
+func (r *Route) flip(reason string, allowFlipBack bool) (*Strategy, error) {
+	r.mux.Lock()
+	current := r.Strategy
+	if current == nil || strings.ToLower(current.Type) != "bluegreen" {
+		r.mux.Unlock()
+		return nil, fmt.Errorf("Flip is only supported with Strategy \"bluegreen\"")
+	}
+	strategy, err := NewBlueGreenStrategy(r, current.Preview, current.Active, current.PreviewHost,
+		current.PreviewPrefix, current.HeaderName, current.HeaderValue, current.FlipBackAfter.Duration)
+	if err != nil {
+		r.mux.Unlock()
+		return nil, err
+	}
+	if allowFlipBack && strategy.FlipBackAfter.Duration > 0 {
+		strategy.flippedAt = time.Now()
+	}
+	r.Strategy = strategy
+	r.mux.Unlock()
+
+	r.updateWeights()
+	r.emitEvent("BlueGreenFlipped", "%s is active and %s is preview: %s",
+		strategy.Active, strategy.Preview, reason)
+	return strategy, nil
+}
+
+// flipBackOnAlarm flips back if the active backend of a bluegreen strategy
+// is alarming within FlipBackAfter of the last flip
+func (r *Route) flipBackOnAlarm(backend *Backend, alert metrics.Alert) {
+	r.mux.RLock()
+	strategy := r.Strategy
+	r.mux.RUnlock()
+
+	if strategy == nil || strings.ToLower(strategy.Type) != "bluegreen" ||
+		strategy.Active != backend.Name || strategy.flippedAt.IsZero() ||
+		time.Since(strategy.flippedAt) > strategy.FlipBackAfter.Duration {
+		return
+	}
+	reason := fmt.Sprintf("Alert (%s) of %s was alarming within %v of the flip",
+		alert.Metric, backend.Name, strategy.FlipBackAfter.Duration)
+	if _, err := r.flip(reason, false); err != nil {
+		log.Errorf("Unable to flip back %s: %v", r.Name, err)
+	}
+}
+
+// PreviewHandle returns the host and prefix on which the preview backend of a
+// bluegreen strategy is reachable. If no separate handle is required, false is returned
+func (r *Route) PreviewHandle() (string, string, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if r.Strategy == nil || strings.ToLower(r.Strategy.Type) != "bluegreen" {
+		return "", "", false
+	}
+	host, prefix := r.Host, r.Prefix
+	if r.Strategy.PreviewHost != "" {
+		host = r.Strategy.PreviewHost
+	}
+	if r.Strategy.PreviewPrefix != "" {
+		prefix = r.Strategy.PreviewPrefix
+	}
+	if host == r.Host && prefix == r.Prefix {
+		return "", "", false
+	}
+	return host, prefix, true
+}
+
+// GetPreviewHandler returns the handler which forwards all requests
+// to the preview backend of a bluegreen strategy
+func (r *Route) GetPreviewHandler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		r.mux.RLock()
+		strategy := r.Strategy
+		logSampleRate := r.AccessLogSampleRate
+		clientCertPolicy := r.ClientCertPolicy
+		r.mux.RUnlock()
+		defer r.applyResponseHeaders(ctx)
+		r.logRoute(ctx, logSampleRate)
+
+		if !r.authorizeClient(ctx, clientCertPolicy) {
+			return
+		}
+
+		if strategy == nil || strings.ToLower(strategy.Type) != "bluegreen" {
+			middleware.Error(ctx, "Not Found", 404)
+			return
+		}
+		var preview *Backend
+		for _, backend := range r.Backends {
+			if backend.Name == strategy.Preview {
+				preview = backend
+			}
+		}
+		if preview == nil {
+			middleware.Error(ctx, "No Upstream Host Available", 503)
+			return
+		}
+		// the preview prefix is replaced so the request can be rewritten like any other
+		if prefix := strategy.PreviewPrefix; prefix != "" && prefix != r.Prefix {
+			path := ctx.URI().Path()
+			if bytes.HasPrefix(path, []byte(prefix)) {
+				ctx.URI().SetPath(r.Prefix + string(path[len(prefix):]))
+			}
+		}
+		r.prepareRequest(ctx)
+		forwardTo(r, ctx, preview, true)
+	}
+}
@@ -0,0 +1,203 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+// States of a CircuitBreaker
+const (
+	CircuitClosed   = "Closed"
+	CircuitHalfOpen = "HalfOpen"
+	CircuitOpen     = "Open"
+)
+
+// ErrCircuitOpen is returned if a request is rejected by the CircuitBreaker of a backend
+var ErrCircuitOpen = fmt.Errorf("Circuit breaker of backend is open")
+
+// CircuitBreaker protects the gateway from backends that are failing or slow.
+// If the ratio of failed requests within Window reaches ErrorRatio, the circuit
+// opens and all requests to the backend fail fast. After OpenFor the circuit
+// is half-open and HalfOpenRequests are used to probe the backend
+type CircuitBreaker struct {
+	// ErrorRatio of failed requests at which the circuit is opened
+	ErrorRatio float64 `json:"error_ratio" yaml:"errorRatio" default:"0.5"`
+	// LatencyThreshold defines when a response is slow and counts as failure. 0 disables it
+	LatencyThreshold util.ConfigDuration `json:"latency_threshold" yaml:"latencyThreshold"`
+	// MinRequests is the amount of requests within Window before the ratio is evaluated
+	MinRequests int `json:"min_requests" yaml:"minRequests" default:"20"`
+	// Window is the timeframe in which requests are counted
+	Window util.ConfigDuration `json:"window" yaml:"window" default:"\"10s\""`
+	// OpenFor is the duration the circuit stays open before probing the backend
+	OpenFor util.ConfigDuration `json:"open_for" yaml:"openFor" default:"\"30s\""`
+	// HalfOpenRequests is the amount of successful probes that are required to close the circuit
+	HalfOpenRequests int `json:"half_open_requests" yaml:"halfOpenRequests" default:"5"`
+	// State is the current state of the circuit
+	State string `json:"state" yaml:"-"`
+
+	routeName   string
+	backendID   string
+	requests    int
+	failures    int
+	probes      int
+	successes   int
+	windowStart time.Time
+	openedAt    time.Time
+	mux         sync.Mutex
+}
+
+// init resets the CircuitBreaker and sets the labels of its Prometheus gauge
+func (cb *CircuitBreaker) init(routeName, backendID string) {
+	if cb == nil {
+		return
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.routeName = routeName
+	cb.backendID = backendID
+	cb.windowStart = time.Now()
+	cb.setState(CircuitClosed)
+}
+
+// MarshalJSON returns the configuration and the current state of the CircuitBreaker
+func (cb *CircuitBreaker) MarshalJSON() ([]byte, error) {
+	type circuitBreaker CircuitBreaker
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	return json.Marshal((*circuitBreaker)(cb))
+}
+
+// publish sets the Prometheus gauge to the current state
+func (cb *CircuitBreaker) publish() {
+	if cb == nil {
+		return
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.setGauge()
+}
+
+// remove deletes the Prometheus gauge of the CircuitBreaker
+func (cb *CircuitBreaker) remove() {
+	if cb == nil {
+		return
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	metrics.CircuitBreakerState.Delete(
+		prometheus.Labels{
+			"route":   cb.routeName,
+			"backend": cb.backendID,
+		},
+	)
+}
+
+// Allow checks if a request can be forwarded to the backend
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.State {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.OpenFor.Duration {
+			return false
+		}
+		cb.setState(CircuitHalfOpen)
+		fallthrough
+
+	case CircuitHalfOpen:
+		if cb.probes >= cb.HalfOpenRequests {
+			return false
+		}
+		cb.probes++
+		return true
+
+	default:
+		return true
+	}
+}
+
+// Report records the outcome of a request that was allowed by the CircuitBreaker
+func (cb *CircuitBreaker) Report(resp *fasthttp.Response, err error, latency time.Duration) {
+	if cb == nil {
+		return
+	}
+	failed := err != nil || resp.StatusCode() >= 500 ||
+		(cb.LatencyThreshold.Duration > 0 && latency > cb.LatencyThreshold.Duration)
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.State {
+	case CircuitHalfOpen:
+		if failed {
+			cb.open()
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.HalfOpenRequests {
+			cb.setState(CircuitClosed)
+		}
+
+	case CircuitClosed:
+		if time.Since(cb.windowStart) > cb.Window.Duration {
+			cb.windowStart = time.Now()
+			cb.requests, cb.failures = 0, 0
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if cb.requests >= cb.MinRequests &&
+			float64(cb.failures)/float64(cb.requests) >= cb.ErrorRatio {
+			cb.open()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	log.Warnf("Opening circuit breaker of backend %s of %s", cb.backendID, cb.routeName)
+	cb.openedAt = time.Now()
+	cb.setState(CircuitOpen)
+}
+
+// setState changes the state and resets all counters. The caller must hold the lock
+func (cb *CircuitBreaker) setState(state string) {
+	if cb.State != "" && cb.State != state {
+		log.Infof("Circuit breaker of backend %s of %s changed from %s to %s",
+			cb.backendID, cb.routeName, cb.State, state)
+	}
+	cb.State = state
+	cb.requests, cb.failures, cb.probes, cb.successes = 0, 0, 0, 0
+	cb.windowStart = time.Now()
+	cb.setGauge()
+}
+
+// setGauge sets the Prometheus gauge to the state. The caller must hold the lock
+func (cb *CircuitBreaker) setGauge() {
+	var value float64
+	switch cb.State {
+	case CircuitHalfOpen:
+		value = 1
+	case CircuitOpen:
+		value = 2
+	}
+	metrics.CircuitBreakerState.With(
+		prometheus.Labels{
+			"route":   cb.routeName,
+			"backend": cb.backendID,
+		},
+	).Set(value)
+}
@@ -0,0 +1,157 @@
+package route
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rgumi/depoy/middleware"
+	"github.com/rgumi/depoy/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// headers which forward the verified client certificate to the backend
+	clientCertHeaders = []string{
+		"X-Client-Cert-Subject",
+		"X-Client-Cert-Issuer",
+		"X-Client-Cert-SAN",
+		"X-Client-Cert-Serial",
+		"X-Client-Cert-Fingerprint",
+	}
+)
+
+// ClientCertPolicy requires a client certificate which is signed by the CA. If
+// Subjects or SANs are configured, the subject or one of the SANs of the certificate
+// must match one of the regular expressions. The identity of the client is forwarded
+// to the backend in the X-Client-Cert-* headers. The gateway must terminate TLS
+type ClientCertPolicy struct {
+	// CAFile is a PEM bundle of the CAs which sign the client certificates
+	CAFile string `json:"ca_file" yaml:"caFile"`
+	// Subjects are matched against the subject (e. g. CN=partner,O=B2B)
+	Subjects []string `json:"subjects,omitempty" yaml:"subjects,omitempty"`
+	// SANs are matched against the DNS names, emails, IPs and URIs of the certificate
+	SANs []string `json:"sans,omitempty" yaml:"sans,omitempty"`
+
+	certs    *util.CertReloader
+	subjects []*regexp.Regexp
+	sans     []*regexp.Regexp
+}
+
+// Validate checks the configuration, loads the CA and compiles the patterns
+func (p *ClientCertPolicy) Validate() error {
+	if p.CAFile == "" {
+		return fmt.Errorf("CAFile of client certificate policy is required")
+	}
+	certs, err := util.NewCertReloader("", "", p.CAFile)
+	if err != nil {
+		return err
+	}
+	subjects, err := compilePatterns(p.Subjects)
+	if err != nil {
+		return err
+	}
+	sans, err := compilePatterns(p.SANs)
+	if err != nil {
+		return err
+	}
+	p.certs, p.subjects, p.sans = certs, subjects, sans
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		regex, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern of client certificate policy (%s)", pattern)
+		}
+		compiled[i] = regex
+	}
+	return compiled, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sans returns the subject alternative names of the certificate
+func sans(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// verify returns the client certificate of the request if it satisfies the policy
+func (p *ClientCertPolicy) verify(ctx *fasthttp.RequestCtx) (*x509.Certificate, error) {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("No client certificate presented")
+	}
+	if err := p.certs.VerifyPeer(state.PeerCertificates, "", x509.ExtKeyUsageClientAuth); err != nil {
+		return nil, err
+	}
+	cert := state.PeerCertificates[0]
+	if len(p.subjects) > 0 && !matchesAny(p.subjects, []string{cert.Subject.String()}) {
+		return nil, fmt.Errorf("Subject %s is not allowed", cert.Subject.String())
+	}
+	if len(p.sans) > 0 && !matchesAny(p.sans, sans(cert)) {
+		return nil, fmt.Errorf("SANs %v are not allowed", sans(cert))
+	}
+	return cert, nil
+}
+
+// SetClientCertPolicy validates and sets the ClientCertPolicy of the route.
+// If policy is nil, no client certificate is required
+func (r *Route) SetClientCertPolicy(policy *ClientCertPolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	r.ClientCertPolicy = policy
+	r.mux.Unlock()
+	return nil
+}
+
+// authorizeClient removes the X-Client-Cert-* headers of the downstream and enforces the
+// ClientCertPolicy. If the request is rejected, the response is written and false is returned
+func (r *Route) authorizeClient(ctx *fasthttp.RequestCtx, policy *ClientCertPolicy) bool {
+	for _, header := range clientCertHeaders {
+		ctx.Request.Header.Del(header)
+	}
+	if policy == nil {
+		return true
+	}
+	cert, err := policy.verify(ctx)
+	if err != nil {
+		log.Debugf("Rejected request of %s to %s: %v", ctx.RemoteIP(), r.Name, err)
+		middleware.Error(ctx, "Forbidden", 403)
+		return false
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	ctx.Request.Header.Set("X-Client-Cert-Subject", cert.Subject.String())
+	ctx.Request.Header.Set("X-Client-Cert-Issuer", cert.Issuer.String())
+	ctx.Request.Header.Set("X-Client-Cert-SAN", strings.Join(sans(cert), ","))
+	ctx.Request.Header.Set("X-Client-Cert-Serial", cert.SerialNumber.String())
+	ctx.Request.Header.Set("X-Client-Cert-Fingerprint", hex.EncodeToString(fingerprint[:]))
+	return true
+}
@@ -0,0 +1,50 @@
+package route
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// MaxEvents is the amount of events that are kept per route
+	MaxEvents = 100
+)
+
+// Event is a notable change of the state of a route, e. g.
+// a failover to another priority tier or a rollback
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Route     string    `json:"route"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+}
+
+// emitEvent logs the event and adds it to the events of the route
+func (r *Route) emitEvent(eventType, format string, args ...interface{}) {
+	event := Event{
+		Timestamp: time.Now(),
+		Route:     r.Name,
+		Type:      eventType,
+		Message:   fmt.Sprintf(format, args...),
+	}
+	log.Warnf("Event %s of %s: %s", event.Type, r.Name, event.Message)
+
+	r.eventMux.Lock()
+	defer r.eventMux.Unlock()
+	r.events = append(r.events, event)
+	if len(r.events) > MaxEvents {
+		r.events = r.events[len(r.events)-MaxEvents:]
+	}
+}
+
+// GetEvents returns the latest events of the route
+func (r *Route) GetEvents() []Event {
+	r.eventMux.Lock()
+	defer r.eventMux.Unlock()
+
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+	return events
+}
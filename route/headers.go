@@ -0,0 +1,156 @@
+package route
+
+import (
+	"net"
+	"strings"
+
+	"github.com/rgumi/depoy/middleware"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// key of the user value which holds the backend that served the request
+	backendKey = "route.backend"
+)
+
+// HeaderOperations are applied to the headers of a request or response in the
+// order remove, set and append. Values may contain the template expressions of a
+// RewritePolicy and {route}, {backend}, {client_ip} and {request_id}
+type HeaderOperations struct {
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	Append map[string]string `json:"append,omitempty" yaml:"append,omitempty"`
+	Remove []string          `json:"remove,omitempty" yaml:"remove,omitempty"`
+}
+
+// HeaderPolicy defines the operations on the headers of the upstream
+// request and of the response that is returned to the client
+type HeaderPolicy struct {
+	Request  *HeaderOperations `json:"request,omitempty" yaml:"request,omitempty"`
+	Response *HeaderOperations `json:"response,omitempty" yaml:"response,omitempty"`
+}
+
+// expandHeaderTemplate replaces the template expressions of value with the
+// attributes of the request, the route and the backend. All expressions are
+// replaced in a single pass, so values of the client (e. g. the request id)
+// are never expanded themselves
+func (r *Route) expandHeaderTemplate(value string, ctx *fasthttp.RequestCtx, backend *Backend) string {
+	backendName := ""
+	if backend != nil {
+		backendName = backend.Name
+	}
+	return expandHeaderValue(value, ctx, r.Name, backendName)
+}
+
+func expandHeaderValue(value string, ctx *fasthttp.RequestCtx, routeName, backendName string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+	return templateExpr.ReplaceAllStringFunc(value, func(expr string) string {
+		switch expr {
+		case "{route}":
+			return routeName
+		case "{backend}":
+			return backendName
+		case "{client_ip}":
+			return ctx.RemoteIP().String()
+		case "{request_id}":
+			return middleware.GetRequestID(ctx)
+		}
+		return expandTemplate(expr, ctx)
+	})
+}
+
+// apply executes the operations using the functions of the header
+func (ops *HeaderOperations) apply(
+	expand func(string) string, del func(string), set, add func(string, string)) {
+
+	if ops == nil {
+		return
+	}
+	for _, name := range ops.Remove {
+		del(name)
+	}
+	for name, value := range ops.Set {
+		set(name, expand(value))
+	}
+	for name, value := range ops.Append {
+		add(name, expand(value))
+	}
+}
+
+// applyRequestHeaders applies the request operations of the route and the
+// backend to the upstream request. The policy of the backend is applied last
+func (r *Route) applyRequestHeaders(ctx *fasthttp.RequestCtx, req *fasthttp.Request, backend *Backend) {
+	r.mux.RLock()
+	policy := r.HeaderPolicy
+	r.mux.RUnlock()
+
+	expand := func(value string) string {
+		return r.expandHeaderTemplate(value, ctx, backend)
+	}
+	for _, p := range []*HeaderPolicy{policy, backend.HeaderPolicy} {
+		if p != nil {
+			p.Request.apply(expand, req.Header.Del, req.Header.Set, req.Header.Add)
+		}
+	}
+}
+
+// applyResponseHeaders applies the response operations of the route and of the
+// backend which served the request. It is applied to every response of the route
+func (r *Route) applyResponseHeaders(ctx *fasthttp.RequestCtx) {
+	r.mux.RLock()
+	policy := r.HeaderPolicy
+	r.mux.RUnlock()
+
+	backend, _ := ctx.UserValue(backendKey).(*Backend)
+	expand := func(value string) string {
+		return r.expandHeaderTemplate(value, ctx, backend)
+	}
+	header := &ctx.Response.Header
+	if backend != nil && backend.HeaderPolicy != nil {
+		backend.HeaderPolicy.Response.apply(expand, header.Del, header.Set, header.Add)
+	}
+	if policy != nil {
+		policy.Response.apply(expand, header.Del, header.Set, header.Add)
+	}
+}
+
+// ApplyToResponse applies the operations to the response of the request. It is used
+// for responses which are not served by a route, therefore {route} and {backend} are empty
+func (ops *HeaderOperations) ApplyToResponse(ctx *fasthttp.RequestCtx) {
+	expand := func(value string) string {
+		return expandHeaderValue(value, ctx, "", "")
+	}
+	header := &ctx.Response.Header
+	ops.apply(expand, header.Del, header.Set, header.Add)
+}
+
+// SetHeaderPolicy sets the HeaderPolicy of the route.
+// If policy is nil, the policy of the route is removed
+func (r *Route) SetHeaderPolicy(policy *HeaderPolicy) {
+	r.mux.Lock()
+	r.HeaderPolicy = policy
+	r.mux.Unlock()
+}
+
+// addForwardedHeaders sets X-Forwarded-Proto and X-Forwarded-Host and appends the
+// client to the Forwarded header (RFC 7239) of the downstream request
+func addForwardedHeaders(ctx *fasthttp.RequestCtx) {
+	proto := "http"
+	if ctx.IsTLS() {
+		proto = "https"
+	}
+	host := string(ctx.Host())
+	ctx.Request.Header.Set("X-Forwarded-Proto", proto)
+	ctx.Request.Header.Set("X-Forwarded-Host", host)
+
+	client := ctx.RemoteIP().String()
+	if ip := ctx.RemoteIP(); ip.To4() == nil && len(ip) == net.IPv6len {
+		client = "\"[" + client + "]\""
+	}
+	forwarded := "for=" + client + ";host=\"" + host + "\";proto=" + proto
+	if prior := ctx.Request.Header.Peek("Forwarded"); len(prior) > 0 {
+		forwarded = string(prior) + ", " + forwarded
+	}
+	ctx.Request.Header.Set("Forwarded", forwarded)
+}
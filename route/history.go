@@ -0,0 +1,298 @@
+package route
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rgumi/depoy/conditional"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// HistoryDir is the absolute directory in which the history of switchovers is stored.
+	// If it is empty, the history is only kept in memory
+	HistoryDir string
+	// MaxHistory is the amount of switchovers that are kept per route
+	MaxHistory int
+
+	historyMux sync.Mutex
+	// version of the history of each running switchover which was written last
+	writtenHistory = make(map[uuid.UUID]int)
+)
+
+func init() {
+	flag.StringVar(&HistoryDir, "switchover.historyDir", "", "absolute directory in which the history of switchovers is stored (defaults to history in the data directory)")
+	flag.IntVar(&MaxHistory, "switchover.maxHistory", 50, "amount of switchovers that are kept in the history of a route")
+}
+
+// InitHistory validates and creates the HistoryDir. If it is not configured,
+// the directory history within dataDir is used
+func InitHistory(dataDir string) error {
+	if HistoryDir == "" {
+		dir, err := filepath.Abs(filepath.Join(dataDir, "history"))
+		if err != nil {
+			return err
+		}
+		HistoryDir = dir
+	}
+	if !filepath.IsAbs(HistoryDir) {
+		return fmt.Errorf("HistoryDir must be an absolute path (%s)", HistoryDir)
+	}
+	return os.MkdirAll(HistoryDir, 0755)
+}
+
+// HistoryRecord is an entry in the timeline of a switchover
+type HistoryRecord struct {
+	Timestamp  time.Time          `json:"timestamp"`
+	Type       string             `json:"type"`
+	Message    string             `json:"message"`
+	FromWeight uint8              `json:"from_weight"`
+	ToWeight   uint8              `json:"to_weight"`
+	Step       int                `json:"step"`
+	Condition  string             `json:"condition,omitempty"`
+	Metrics    map[string]float64 `json:"metrics,omitempty"`
+}
+
+// SwitchoverHistory is the lifecycle of a switchover
+type SwitchoverHistory struct {
+	ID      uuid.UUID       `json:"id"`
+	Route   string          `json:"route"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Started time.Time       `json:"started"`
+	Ended   time.Time       `json:"ended"`
+	Status  string          `json:"status"`
+	Records []HistoryRecord `json:"records"`
+	version int
+}
+
+// copy returns a copy of the history which can be used without holding the lock
+func (h *SwitchoverHistory) copy() *SwitchoverHistory {
+	history := *h
+	history.Records = make([]HistoryRecord, len(h.Records))
+	copy(history.Records, h.Records)
+	return &history
+}
+
+// record adds an entry to the history of the switchover and persists
+// the history in the background. The caller must hold the lock
+func (s *Switchover) record(
+	recordType, message string, condition *conditional.Condition, metrics map[string]float64) {
+
+	if s.history == nil {
+		s.history = &SwitchoverHistory{
+			ID:      s.ID,
+			Route:   s.Route.Name,
+			From:    s.From.Name,
+			To:      s.To.Name,
+			Started: time.Now(),
+		}
+	}
+	entry := HistoryRecord{
+		Timestamp:  time.Now(),
+		Type:       recordType,
+		Message:    message,
+		FromWeight: s.From.Weigth,
+		ToWeight:   s.To.Weigth,
+		Step:       s.CurrentStep,
+		Metrics:    metrics,
+	}
+	if condition != nil {
+		entry.Condition = fmt.Sprintf("%s %s %v", condition.Metric, condition.Operator, condition.Threshold)
+	}
+	s.history.Status = s.Status
+	s.history.Records = append(s.history.Records, entry)
+	s.persist()
+}
+
+// finish marks the history as ended and persists it. The caller must hold the lock
+func (s *Switchover) finish() {
+	if s.history == nil || !s.history.Ended.IsZero() {
+		return
+	}
+	s.history.Ended = time.Now()
+	s.history.Status = s.Status
+	s.persist()
+}
+
+// persist writes a copy of the history without holding the lock. The caller must hold the lock
+func (s *Switchover) persist() {
+	if HistoryDir == "" && s.history.Ended.IsZero() {
+		// the history of the current switchover is read from memory
+		return
+	}
+	s.history.version++
+	history := s.history.copy()
+	go func() {
+		if err := s.Route.persistHistory(history); err != nil {
+			log.Errorf("Unable to persist history of switchover %v of %s: %v", history.ID, history.Route, err)
+		}
+	}()
+}
+
+// History returns a copy of the history of the switchover
+func (s *Switchover) History() *SwitchoverHistory {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.history == nil {
+		return nil
+	}
+	return s.history.copy()
+}
+
+// historyDir returns the directory which contains a file for each switchover of the route.
+// Dots are escaped as well so that the name cannot refer to a directory outside of HistoryDir
+func (r *Route) historyDir() string {
+	return filepath.Join(HistoryDir, strings.ReplaceAll(url.PathEscape(r.Name), ".", "%2E"))
+}
+
+// readHistory reads the persisted history of the route sorted by the start of
+// the switchovers. The caller must hold historyMux
+func (r *Route) readHistory() ([]*SwitchoverHistory, error) {
+	histories := []*SwitchoverHistory{}
+	if HistoryDir == "" {
+		r.eventMux.Lock()
+		defer r.eventMux.Unlock()
+		return append(histories, r.history...), nil
+	}
+	files, err := ioutil.ReadDir(r.historyDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return histories, nil
+		}
+		return nil, err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(r.historyDir(), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		history := new(SwitchoverHistory)
+		if err = json.Unmarshal(b, history); err != nil {
+			log.Warnf("Skipping invalid history file %s: %v", file.Name(), err)
+			continue
+		}
+		histories = append(histories, history)
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Started.Before(histories[j].Started)
+	})
+	return histories, nil
+}
+
+// persistHistory writes the history of a switchover. Older versions of the
+// history are ignored. If the switchover has ended, the oldest histories
+// of the route are removed so that at most MaxHistory are kept
+func (r *Route) persistHistory(history *SwitchoverHistory) error {
+	historyMux.Lock()
+	defer historyMux.Unlock()
+
+	written, found := writtenHistory[history.ID]
+	if written >= history.version {
+		return nil
+	}
+	if history.Ended.IsZero() {
+		writtenHistory[history.ID] = history.version
+	} else {
+		// the final version is written last, so later writes of the switchover are outdated
+		delete(writtenHistory, history.ID)
+	}
+
+	if HistoryDir == "" {
+		r.eventMux.Lock()
+		defer r.eventMux.Unlock()
+		r.history = append(r.history, history)
+		if len(r.history) > MaxHistory {
+			r.history = r.history[len(r.history)-MaxHistory:]
+		}
+		return nil
+	}
+	if !filepath.IsAbs(HistoryDir) {
+		return fmt.Errorf("HistoryDir must be an absolute path (%s)", HistoryDir)
+	}
+	file := filepath.Join(r.historyDir(), history.ID.String()+".json")
+	if !found && history.Ended.IsZero() {
+		// an outdated version must not replace the history of a switchover that has ended
+		if _, err := os.Stat(file); err == nil {
+			return nil
+		}
+	}
+	b, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(r.historyDir(), 0755); err != nil {
+		return err
+	}
+	// the file is replaced atomically so that a crash never leaves a partial history
+	tmp, err := ioutil.TempFile(r.historyDir(), ".history-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if history.Ended.IsZero() {
+		return nil
+	}
+
+	histories, err := r.readHistory()
+	if err != nil {
+		return err
+	}
+	for len(histories) > MaxHistory {
+		if err := os.Remove(filepath.Join(r.historyDir(), histories[0].ID.String()+".json")); err != nil {
+			return err
+		}
+		histories = histories[1:]
+	}
+	return nil
+}
+
+// GetSwitchoverHistory returns the history of all past switchovers of the route
+// and of the current switchover
+func (r *Route) GetSwitchoverHistory() ([]*SwitchoverHistory, error) {
+	historyMux.Lock()
+	histories, err := r.readHistory()
+	historyMux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Switchover != nil {
+		current := r.Switchover.History()
+		if current != nil && current.Ended.IsZero() {
+			// the persisted history of the current switchover may be outdated
+			for i, history := range histories {
+				if history.ID == current.ID {
+					histories = append(histories[:i], histories[i+1:]...)
+					break
+				}
+			}
+			histories = append(histories, current)
+		}
+	}
+	return histories, nil
+}
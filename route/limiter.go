@@ -0,0 +1,224 @@
+package route
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rgumi/depoy/util"
+)
+
+var (
+	// RetryAfter is the value of the Retry-After header (in seconds) when a request is
+	// rejected due to a concurrency limit
+	RetryAfter = "1"
+
+	// ErrQueueFull is returned if the concurrency limit is reached and no request can be queued
+	ErrQueueFull = fmt.Errorf("Concurrency limit reached and request queue is full")
+	// ErrQueueTimeout is returned if a queued request did not get a slot within the QueueTimeout
+	ErrQueueTimeout = fmt.Errorf("Timed out waiting in request queue")
+
+	// amount of samples after which the minimal RTT is measured again
+	adaptiveResetSamples = 1000
+	// smoothing factor of the adaptive limit
+	adaptiveSmoothing = 0.2
+	// factor by which the adaptive limit is decreased after a failed request
+	adaptiveBackoff = 0.9
+)
+
+// ConcurrencyLimit limits the amount of requests that are in-flight at the same time.
+// Requests above the limit are queued (FIFO) for QueueTimeout. If the queue is full
+// requests are rejected. If Adaptive is set, the limit is adjusted between MinLimit
+// and MaxInFlight based on the latency gradient and failures (AIMD)
+type ConcurrencyLimit struct {
+	// MaxInFlight is the maximal amount of concurrent requests. 0 disables the limit
+	MaxInFlight int `json:"max_in_flight" yaml:"maxInFlight"`
+	// QueueSize is the maximal amount of requests waiting for a slot
+	QueueSize int `json:"queue_size" yaml:"queueSize"`
+	// QueueTimeout is the maximal time a request waits for a slot
+	QueueTimeout util.ConfigDuration `json:"queue_timeout" yaml:"queueTimeout" default:"\"1s\""`
+	// Adaptive enables adaptive concurrency limiting
+	Adaptive bool `json:"adaptive" yaml:"adaptive"`
+	// MinLimit is the lower bound of the adaptive limit
+	MinLimit int `json:"min_limit" yaml:"minLimit" default:"1"`
+
+	inFlight     int
+	adaptedLimit float64
+	queue        *list.List
+	minRTT       time.Duration
+	samples      int
+	mux          sync.Mutex
+}
+
+// Validate checks if the ConcurrencyLimit is valid
+func (l *ConcurrencyLimit) Validate() error {
+	if l.MaxInFlight < 0 {
+		return fmt.Errorf("MaxInFlight of concurrency limit cannot be negative")
+	}
+	if l.QueueSize < 0 {
+		return fmt.Errorf("QueueSize of concurrency limit cannot be negative")
+	}
+	if l.QueueTimeout.Duration < 0 {
+		return fmt.Errorf("QueueTimeout of concurrency limit cannot be negative")
+	}
+	if l.MinLimit < 0 {
+		return fmt.Errorf("MinLimit of concurrency limit cannot be negative")
+	}
+	if l.MaxInFlight > 0 && l.MinLimit > l.MaxInFlight {
+		return fmt.Errorf("MinLimit of concurrency limit cannot be greater than MaxInFlight")
+	}
+	return nil
+}
+
+// MarshalJSON returns the configuration and the current state of the ConcurrencyLimit
+func (l *ConcurrencyLimit) MarshalJSON() ([]byte, error) {
+	type concurrencyLimit ConcurrencyLimit
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	queued := 0
+	if l.queue != nil {
+		queued = l.queue.Len()
+	}
+	return json.Marshal(struct {
+		*concurrencyLimit
+		InFlight int     `json:"in_flight"`
+		Queued   int     `json:"queued"`
+		Limit    float64 `json:"limit"`
+	}{
+		concurrencyLimit: (*concurrencyLimit)(l),
+		InFlight:         l.inFlight,
+		Queued:           queued,
+		Limit:            float64(l.limit()),
+	})
+}
+
+// init resets the state of the ConcurrencyLimit
+func (l *ConcurrencyLimit) init() {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.inFlight = 0
+	l.queue = list.New()
+	l.adaptedLimit = float64(l.MaxInFlight)
+	l.minRTT = 0
+	l.samples = 0
+}
+
+func (l *ConcurrencyLimit) enabled() bool {
+	return l != nil && l.MaxInFlight > 0
+}
+
+// limit returns the current limit. The caller must hold the lock
+func (l *ConcurrencyLimit) limit() int {
+	if l.queue == nil {
+		l.queue = list.New()
+		l.adaptedLimit = float64(l.MaxInFlight)
+	}
+	if !l.Adaptive {
+		return l.MaxInFlight
+	}
+	return int(l.adaptedLimit)
+}
+
+// Acquire blocks until a slot is available. If the limit is reached
+// the request is queued until QueueTimeout
+func (l *ConcurrencyLimit) Acquire() error {
+	if !l.enabled() {
+		return nil
+	}
+	l.mux.Lock()
+	if l.inFlight < l.limit() && l.queue.Len() == 0 {
+		l.inFlight++
+		l.mux.Unlock()
+		return nil
+	}
+	if l.queue.Len() >= l.QueueSize {
+		l.mux.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mux.Unlock()
+
+	timer := time.NewTimer(l.QueueTimeout.Duration)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		l.mux.Lock()
+		defer l.mux.Unlock()
+		select {
+		case <-ready:
+			// the slot was handed over while timing out
+			return nil
+		default:
+		}
+		l.queue.Remove(elem)
+		return ErrQueueTimeout
+	}
+}
+
+// TryAcquire returns a slot if one is available without queueing
+func (l *ConcurrencyLimit) TryAcquire() bool {
+	if !l.enabled() {
+		return true
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.inFlight < l.limit() && l.queue.Len() == 0 {
+		l.inFlight++
+		return true
+	}
+	return false
+}
+
+// Release returns the slot of a request. The latency and outcome
+// of the request are used to adapt the limit
+func (l *ConcurrencyLimit) Release(latency time.Duration, failed bool) {
+	if !l.enabled() {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.Adaptive {
+		l.adapt(latency, failed)
+	}
+	l.inFlight--
+	// hand over free slots to the queued requests in order
+	for l.inFlight < l.limit() && l.queue.Len() > 0 {
+		ready := l.queue.Remove(l.queue.Front()).(chan struct{})
+		close(ready)
+		l.inFlight++
+	}
+}
+
+// adapt updates the limit using the gradient of the minimal and the current latency
+// and decreases it multiplicatively on failures. The caller must hold the lock
+func (l *ConcurrencyLimit) adapt(latency time.Duration, failed bool) {
+	l.limit()
+	newLimit := l.adaptedLimit
+	if failed {
+		newLimit = l.adaptedLimit * adaptiveBackoff
+	} else if latency > 0 {
+		l.samples++
+		if l.minRTT == 0 || latency < l.minRTT || l.samples > adaptiveResetSamples {
+			l.minRTT = latency
+			l.samples = 0
+		}
+		gradient := math.Max(0.5, math.Min(1, float64(l.minRTT)/float64(latency)))
+		newLimit = l.adaptedLimit*gradient + math.Sqrt(l.adaptedLimit)
+		newLimit = (1-adaptiveSmoothing)*l.adaptedLimit + adaptiveSmoothing*newLimit
+	}
+	l.adaptedLimit = math.Max(math.Max(1, float64(l.MinLimit)), math.Min(float64(l.MaxInFlight), newLimit))
+}
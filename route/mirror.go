@@ -0,0 +1,155 @@
+package route
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/middleware"
+	"github.com/rgumi/depoy/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+// Mirror copies a sample of the requests of a route to a backend. The responses
+// of the mirror are discarded. Mirroring is independent of the strategy of the route
+type Mirror struct {
+	// Backend is the name of the backend which receives the copies
+	Backend string `json:"backend" yaml:"backend"`
+	// Percentage of the requests which are mirrored
+	Percentage uint8 `json:"percentage" yaml:"percentage" default:"100"`
+	// HeaderName only mirrors requests with the header. If HeaderValue is empty, any value matches
+	HeaderName  string `json:"header_name,omitempty" yaml:"headerName,omitempty"`
+	HeaderValue string `json:"header_value,omitempty" yaml:"headerValue,omitempty"`
+	// PathPattern only mirrors requests whose path matches the pattern
+	PathPattern string `json:"path_pattern,omitempty" yaml:"pathPattern,omitempty"`
+	// MaxInFlight is the maximal amount of concurrent mirror requests. Requests above are dropped
+	MaxInFlight int `json:"max_in_flight" yaml:"maxInFlight" default:"100"`
+	// Timeout of a mirror request. If 0, the ReadTimeout of the route is used
+	Timeout util.ConfigDuration `json:"timeout" yaml:"timeout"`
+
+	backend *Backend
+	pattern *regexp.Regexp
+	limit   *ConcurrencyLimit
+}
+
+// Validate checks the configuration and prepares the Mirror for usage
+func (m *Mirror) Validate(r *Route) error {
+	if m.Backend == "" {
+		return fmt.Errorf("Backend of mirror is required")
+	}
+	if m.Percentage == 0 || m.Percentage > 100 {
+		return fmt.Errorf("Percentage of mirror must be between 1 and 100")
+	}
+	if m.MaxInFlight <= 0 {
+		return fmt.Errorf("MaxInFlight of mirror must be greater than 0")
+	}
+	if m.PathPattern != "" {
+		pattern, err := regexp.Compile(m.PathPattern)
+		if err != nil {
+			return fmt.Errorf("Invalid path pattern of mirror (%s)", m.PathPattern)
+		}
+		m.pattern = pattern
+	}
+	for _, backend := range r.Backends {
+		if backend.Name == m.Backend {
+			m.backend = backend
+		}
+	}
+	if m.backend == nil {
+		return fmt.Errorf("Unable to find backend %s of mirror", m.Backend)
+	}
+	m.limit = &ConcurrencyLimit{MaxInFlight: m.MaxInFlight}
+	return nil
+}
+
+// matches returns if the request should be mirrored
+func (m *Mirror) matches(ctx *fasthttp.RequestCtx) bool {
+	if m.HeaderName != "" {
+		value := ctx.Request.Header.Peek(m.HeaderName)
+		if len(value) == 0 || (m.HeaderValue != "" && string(value) != m.HeaderValue) {
+			return false
+		}
+	}
+	if m.pattern != nil && !m.pattern.Match(ctx.Path()) {
+		return false
+	}
+	return m.Percentage >= 100 || rand.Intn(100) < int(m.Percentage)
+}
+
+// SetMirrors validates and sets the mirrors of the route.
+// The backends of the mirrors must already be part of the route
+func (r *Route) SetMirrors(mirrors []*Mirror) error {
+	for _, mirror := range mirrors {
+		if err := mirror.Validate(r); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.Mirrors = mirrors
+	return nil
+}
+
+// startMirrors sends a copy of the request to all matching mirrors. The copies
+// are taken before the request is forwarded, the mirror requests are sent in the background
+func (r *Route) startMirrors(ctx *fasthttp.RequestCtx, mirrors []*Mirror) {
+	for _, mirror := range mirrors {
+		if !mirror.matches(ctx) {
+			continue
+		}
+		if !mirror.limit.TryAcquire() {
+			log.Debugf("Dropped mirror request of %s to %s", r.Name, mirror.Backend)
+			continue
+		}
+		req := fasthttp.AcquireRequest()
+		ctx.Request.CopyTo(req)
+		delRequestHopHeader(req)
+		appendXForwardForHeader(req, ctx.RemoteAddr().String())
+		go r.sendMirror(req, mirror)
+	}
+}
+
+// sendMirror sends the mirror request and discards the response. Only the
+// metrics of the mirror backend are recorded
+func (r *Route) sendMirror(req *fasthttp.Request, mirror *Mirror) {
+	defer fasthttp.ReleaseRequest(req)
+	defer mirror.limit.Release(0, false)
+
+	target := mirror.backend
+	if !target.ConcurrencyLimit.TryAcquire() {
+		log.Debugf("Dropped mirror request of %s to %s", r.Name, mirror.Backend)
+		return
+	}
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+	req.URI().CopyTo(uri)
+	r.formateURI(uri, target)
+	req.SetRequestURI(uri.String())
+
+	m := metrics.AcquireMetrics()
+	m.Route = r.Name
+	m.BackendID = target.ID
+	m.RequestMethod = string(req.Header.Method())
+	m.DSContentLength = int64(req.Header.ContentLength())
+	m.Attempt = 1
+	m.RequestID = string(req.Header.Peek(middleware.RequestIDHeader))
+
+	start := time.Now()
+	resp, err := r.sendUpstream(req, target, m, mirror.Timeout.Duration)
+	target.ConcurrencyLimit.Release(time.Since(start), err != nil || resp.StatusCode() >= 500)
+	if err != nil {
+		log.Infof("Mirror request %s of %s to %s failed with %s",
+			m.RequestID, r.Name, mirror.Backend, err.Error())
+		m.ResponseStatus = 600
+		m.ContentLength = -1
+		r.MetricsRepo.InChannel <- m
+		return
+	}
+	defer fasthttp.ReleaseResponse(resp)
+	m.ResponseStatus = resp.StatusCode()
+	m.ContentLength = int64(resp.Header.ContentLength())
+	r.MetricsRepo.InChannel <- m
+}
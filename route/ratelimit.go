@@ -0,0 +1,151 @@
+package route
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rgumi/depoy/util"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// interval in which full buckets are removed from a RateLimit
+	rateLimitCleanupInterval = time.Minute
+)
+
+// RateLimit limits the amount of requests of a route using token buckets.
+// Each client, identified by Key, has its own bucket which holds up to Burst
+// tokens and is refilled with Requests tokens per Period
+type RateLimit struct {
+	// Requests is the amount of tokens that are refilled each Period
+	Requests int `json:"requests" yaml:"requests"`
+	// Period in which Requests are allowed
+	Period util.ConfigDuration `json:"period" yaml:"period" default:"\"1s\""`
+	// Burst is the maximal amount of tokens of a bucket. If 0, Requests is used
+	Burst int `json:"burst" yaml:"burst"`
+	// Key identifies the client. allowed: ip, header, path
+	Key string `json:"key" yaml:"key" default:"ip"`
+	// Header is used as key if Key is header (e. g. an API key). Falls back to the client ip
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	// PathPattern is used as key if Key is path. Only requests matching the pattern are limited
+	PathPattern string `json:"path_pattern,omitempty" yaml:"pathPattern,omitempty"`
+
+	pattern     *regexp.Regexp
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mux         sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// Validate checks the configuration and prepares the RateLimit for usage
+func (rl *RateLimit) Validate() error {
+	if rl.Requests <= 0 || rl.Period.Duration <= 0 {
+		return fmt.Errorf("Requests and Period of rate limit must be greater than 0")
+	}
+	switch strings.ToLower(rl.Key) {
+	case "ip":
+	case "header":
+		if rl.Header == "" {
+			return fmt.Errorf("Rate limit with key header requires a header name")
+		}
+	case "path":
+		pattern, err := regexp.Compile(rl.PathPattern)
+		if err != nil || rl.PathPattern == "" {
+			return fmt.Errorf("Rate limit with key path requires a valid path pattern")
+		}
+		rl.pattern = pattern
+	default:
+		return fmt.Errorf("Unsupported rate limit key (%s)", rl.Key)
+	}
+	rl.buckets = make(map[string]*tokenBucket)
+	rl.lastCleanup = time.Now()
+	return nil
+}
+
+func (rl *RateLimit) capacity() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return float64(rl.Requests)
+}
+
+// tokens per second
+func (rl *RateLimit) rate() float64 {
+	return float64(rl.Requests) / rl.Period.Duration.Seconds()
+}
+
+// key returns the key of the bucket for the request. If the
+// request is not subject to the RateLimit, false is returned
+func (rl *RateLimit) key(ctx *fasthttp.RequestCtx) (string, bool) {
+	switch strings.ToLower(rl.Key) {
+	case "header":
+		if value := ctx.Request.Header.Peek(rl.Header); len(value) > 0 {
+			return string(value), true
+		}
+	case "path":
+		match := rl.pattern.Find(ctx.URI().Path())
+		if match == nil {
+			return "", false
+		}
+		return string(match), true
+	}
+	return ctx.RemoteIP().String(), true
+}
+
+// Allow takes a token from the bucket of the request. It returns if the request
+// is allowed, the remaining tokens and the time until the next token is available
+func (rl *RateLimit) Allow(ctx *fasthttp.RequestCtx) (bool, int, time.Duration) {
+	key, limited := rl.key(ctx)
+	if !limited {
+		return true, -1, 0
+	}
+
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastCleanup) > rateLimitCleanupInterval {
+		rl.cleanup(now)
+	}
+	bucket, found := rl.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: rl.capacity(), lastRefill: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(rl.capacity(), bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*rl.rate())
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / rl.rate() * float64(time.Second))
+		return false, 0, wait
+	}
+	bucket.tokens--
+	reset := time.Duration((rl.capacity() - bucket.tokens) / rl.rate() * float64(time.Second))
+	return true, int(bucket.tokens), reset
+}
+
+// cleanup removes all buckets that would be full by now. The caller must hold the lock
+func (rl *RateLimit) cleanup(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*rl.rate() >= rl.capacity() {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastCleanup = now
+}
+
+// setHeaders sets the RateLimit-* headers of the response
+func (rl *RateLimit) setHeaders(ctx *fasthttp.RequestCtx, remaining int, reset time.Duration) {
+	ctx.Response.Header.Set("RateLimit-Limit", strconv.Itoa(int(rl.capacity())))
+	ctx.Response.Header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	ctx.Response.Header.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
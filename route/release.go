@@ -0,0 +1,286 @@
+package route
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rgumi/depoy/conditional"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReleaseMember defines the switchover of a route which is part of a release
+type ReleaseMember struct {
+	Route string `json:"route" yaml:"route"`
+	From  string `json:"from" yaml:"from"`
+	To    string `json:"to" yaml:"to"`
+}
+
+// Release groups switchovers of multiple routes. The weights of all switchovers
+// are changed in lockstep if the conditions of all switchovers are met.
+// If the release fails or is aborted, all switchovers are rolled back
+type Release struct {
+	Name            string
+	Status          string
+	Switchovers     []*Switchover
+	WeightChange    uint8
+	Timeout         time.Duration
+	AllowedFailures int
+	FailureCounter  int
+	Rollback        bool
+	Transitions     []Transition
+	pausedByWindow  bool // paused as the weights of a route cannot be changed
+	killChan        chan int
+	mux             sync.Mutex
+}
+
+// NewRelease creates a switchover for each member and sets it for its route.
+// Each switchover evaluates its own copy of the conditions. All members are
+// validated before any route is changed, so an invalid member leaves all routes as they are
+func NewRelease(
+	name string,
+	routes map[string]*Route,
+	members []*ReleaseMember,
+	conditions []*conditional.Condition,
+	timeout time.Duration, allowedFailures int,
+	weightChange uint8, force, rollback bool) (*Release, error) {
+
+	if name == "" || len(members) == 0 {
+		return nil, fmt.Errorf("Required parameter are missing")
+	}
+
+	release := &Release{
+		Name:            name,
+		Status:          "Registered",
+		Switchovers:     make([]*Switchover, len(members)),
+		WeightChange:    weightChange,
+		Timeout:         timeout,
+		AllowedFailures: allowedFailures,
+		Rollback:        rollback,
+		killChan:        make(chan int, 1),
+	}
+	memberRoutes := make(map[string]bool, len(members))
+	for i, member := range members {
+		r, found := routes[member.Route]
+		if !found {
+			return nil, fmt.Errorf("Could not find route %s", member.Route)
+		}
+		if memberRoutes[member.Route] {
+			return nil, fmt.Errorf("Route %s is part of the release more than once", member.Route)
+		}
+		memberRoutes[member.Route] = true
+
+		memberConditions := make([]*conditional.Condition, len(conditions))
+		for j, condition := range conditions {
+			c := *condition
+			memberConditions[j] = &c
+		}
+		switchover, err := r.newSwitchOver(member.From, member.To, memberConditions, nil,
+			timeout, time.Time{}, 0, weightChange, force, rollback)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create switchover of %s: %v", member.Route, err)
+		}
+		switchover.Release = name
+		release.Switchovers[i] = switchover
+	}
+
+	// all members are valid
+	for _, switchover := range release.Switchovers {
+		switchover.Route.Switchover = switchover
+	}
+	return release, nil
+}
+
+// IsActive returns if the release is running or paused
+func (rel *Release) IsActive() bool {
+	rel.mux.Lock()
+	defer rel.mux.Unlock()
+	return rel.Status == "Running" || rel.Status == "Paused"
+}
+
+// setStatus updates the status and records the transition. The caller must hold the lock
+func (rel *Release) setStatus(status, reason string) {
+	rel.Transitions = append(rel.Transitions, Transition{
+		Timestamp: time.Now(),
+		From:      rel.Status,
+		To:        status,
+		Reason:    reason,
+	})
+	log.Warnf("Release %s changed from %s to %s: %s", rel.Name, rel.Status, status, reason)
+	rel.Status = status
+}
+
+// lock locks the release and all its switchovers
+func (rel *Release) lock() {
+	rel.mux.Lock()
+	for _, s := range rel.Switchovers {
+		s.mux.Lock()
+	}
+}
+
+func (rel *Release) unlock() {
+	for _, s := range rel.Switchovers {
+		s.mux.Unlock()
+	}
+	rel.mux.Unlock()
+}
+
+// Start the release process
+func (rel *Release) Start() {
+	rel.lock()
+	for _, s := range rel.Switchovers {
+		s.toRollbackWeight = s.To.Weigth
+		s.fromRollbackWeight = s.From.Weigth
+		s.setStatus("Running", fmt.Sprintf("Release %s was started", rel.Name))
+	}
+	rel.setStatus("Running", "Release was started")
+	rel.unlock()
+
+	for {
+		select {
+		case _ = <-rel.killChan:
+			log.Warnf("Killed Release %s", rel.Name)
+			return
+
+		case now := <-time.After(rel.Timeout):
+			rel.cycle(now)
+		}
+	}
+}
+
+// cycle evaluates the conditions of all switchovers. The weights are only
+// changed if the conditions of all switchovers are met
+func (rel *Release) cycle(now time.Time) {
+	rel.lock()
+	defer rel.unlock()
+
+	if rel.Status != "Running" && !(rel.Status == "Paused" && rel.pausedByWindow) {
+		return
+	}
+	for _, s := range rel.Switchovers {
+		if s.Status != "Running" {
+			rel.abort(fmt.Sprintf("Switchover of %s was stopped", s.Route.Name))
+			return
+		}
+	}
+	for _, s := range rel.Switchovers {
+		if blocked := s.blocked(now); blocked != "" {
+			if rel.Status == "Running" {
+				rel.pausedByWindow = true
+				rel.setStatus("Paused", fmt.Sprintf("Weights of %s cannot be changed: %s", s.Route.Name, blocked))
+			}
+			return
+		}
+	}
+	if rel.Status == "Paused" {
+		rel.pausedByWindow = false
+		rel.setStatus("Running", "Weights of all routes can be changed again")
+	}
+	begun := false
+	for _, s := range rel.Switchovers {
+		if !s.started {
+			s.begin()
+			begun = begun || s.canary != nil
+		}
+	}
+	if begun {
+		// the initial weights were set, so there are no metrics to evaluate yet
+		return
+	}
+
+	var failed, inconclusive *Switchover
+	var failedCondition *conditional.Condition
+	metricsBySwitchover := make(map[*Switchover]map[string]float64, len(rel.Switchovers))
+
+	for _, s := range rel.Switchovers {
+		outcome, condition, message, metrics := s.evaluate(now)
+		metricsBySwitchover[s] = metrics
+		switch outcome {
+		case cycleFailed:
+			s.record("CycleFailed", fmt.Sprintf("Cycle of release %s failed", rel.Name), condition, metrics)
+			if failed == nil {
+				failed, failedCondition = s, condition
+			}
+		case cycleInconclusive:
+			s.record("CycleInconclusive", message, condition, metrics)
+			inconclusive = s
+		}
+	}
+
+	if failed != nil {
+		rel.FailureCounter++
+		log.Debugf("Condition (%s) of release %s (%s) is false",
+			failedCondition.Metric, rel.Name, failed.Route.Name)
+		if rel.AllowedFailures > 0 && rel.FailureCounter > rel.AllowedFailures {
+			rel.fail(fmt.Sprintf("Condition (%s) of %s was false in %d cycles",
+				failedCondition.Metric, failed.Route.Name, rel.FailureCounter))
+		}
+		return
+	}
+	if inconclusive != nil {
+		return
+	}
+
+	done := true
+	for _, s := range rel.Switchovers {
+		if !s.done() {
+			s.shiftWeights(rel.WeightChange,
+				fmt.Sprintf("All conditions of release %s were met", rel.Name), metricsBySwitchover[s])
+		}
+		done = done && s.done()
+	}
+	if done {
+		for _, s := range rel.Switchovers {
+			s.setStatus("Success", fmt.Sprintf("Release %s was successful", rel.Name))
+			s.stop()
+		}
+		rel.setStatus("Success", "All traffic is forwarded to the new backends")
+		rel.stop()
+	}
+}
+
+// fail stops all switchovers. If Rollback is set, all weights are reset. The caller must hold the lock
+func (rel *Release) fail(reason string) {
+	for _, s := range rel.Switchovers {
+		if s.Status == "Running" {
+			s.setStatus("Failed", reason)
+			s.stop()
+		}
+	}
+	rel.setStatus("Failed", reason)
+	rel.stop()
+}
+
+// abort stops all switchovers and resets their weights. The caller must hold the lock
+func (rel *Release) abort(reason string) {
+	for _, s := range rel.Switchovers {
+		s.rollback()
+		if s.Status == "Running" {
+			s.setStatus("Aborted", reason)
+			s.stop()
+		}
+	}
+	rel.setStatus("Aborted", reason)
+	rel.stop()
+}
+
+// Abort stops the release and resets the weights of all switchovers
+func (rel *Release) Abort(reason string) error {
+	rel.lock()
+	defer rel.unlock()
+
+	if rel.Status != "Running" && rel.Status != "Paused" {
+		return fmt.Errorf("Only an active release can be aborted (Status: %s)", rel.Status)
+	}
+	rel.abort(reason)
+	return nil
+}
+
+// stop the release process. The caller must hold the lock
+func (rel *Release) stop() {
+	select {
+	case rel.killChan <- 1:
+	default:
+		// release was already stopped
+	}
+}
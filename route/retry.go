@@ -0,0 +1,164 @@
+package route
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rgumi/depoy/util"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// DefaultBackoffBase is the base interval of the backoff between two attempts
+	DefaultBackoffBase = 25 * time.Millisecond
+	// DefaultBackoffMax is the maximal interval of the backoff between two attempts
+	DefaultBackoffMax = 250 * time.Millisecond
+
+	// requests with these methods can be sent multiple times without side-effects
+	idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+	// conditions which can be used in RetryOn
+	retryConditions = []string{"connect-failure", "reset", "5xx", "503", "retriable-status-codes"}
+)
+
+// RetryPolicy defines if and how failed requests to the upstream
+// are retried. Retries are forwarded to another backend of the
+// route if one is available and the strategy selected the backend by weight.
+// Otherwise all attempts are sent to the same backend
+type RetryPolicy struct {
+	// Attempts is the maximal amount of attempts (including the first one)
+	Attempts int `json:"attempts" yaml:"attempts" default:"2"`
+	// RetryOn defines the conditions under which a request is retried
+	// allowed: connect-failure, reset, 5xx, 503, retriable-status-codes
+	RetryOn []string `json:"retry_on" yaml:"retryOn" default:"[\"connect-failure\"]"`
+	// StatusCodes are retried if RetryOn contains retriable-status-codes
+	StatusCodes []int `json:"status_codes,omitempty" yaml:"statusCodes,omitempty"`
+	// PerTryTimeout is the timeout of a single attempt. If 0 the ReadTimeout of the route is used
+	PerTryTimeout util.ConfigDuration `json:"per_try_timeout" yaml:"perTryTimeout"`
+	// BackoffBase and BackoffMax define the jittered exponential backoff between attempts
+	BackoffBase util.ConfigDuration `json:"backoff_base" yaml:"backoffBase" default:"\"25ms\""`
+	BackoffMax  util.ConfigDuration `json:"backoff_max" yaml:"backoffMax" default:"\"250ms\""`
+}
+
+// Validate checks the configuration of the RetryPolicy
+func (p *RetryPolicy) Validate() error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("Attempts of retry policy must be at least 1")
+	}
+outer:
+	for _, condition := range p.RetryOn {
+		for _, known := range retryConditions {
+			if strings.ToLower(condition) == known {
+				continue outer
+			}
+		}
+		return fmt.Errorf("Unsupported retry condition (%s)", condition)
+	}
+	if p.retryOn("retriable-status-codes") && len(p.StatusCodes) == 0 {
+		return fmt.Errorf("Retry condition retriable-status-codes requires status codes")
+	}
+	for _, code := range p.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("Invalid retriable status code (%d)", code)
+		}
+	}
+	return nil
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.Attempts < 1 {
+		return 1
+	}
+	return p.Attempts
+}
+
+func (p *RetryPolicy) perTryTimeout() time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.PerTryTimeout.Duration
+}
+
+func (p *RetryPolicy) retryOn(condition string) bool {
+	for _, c := range p.RetryOn {
+		if strings.ToLower(c) == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry checks if the outcome of an attempt is retriable.
+// Requests with non-idempotent methods are only retried if the request
+// could not have reached the upstream application
+func (p *RetryPolicy) shouldRetry(method string, resp *fasthttp.Response, err error) bool {
+	if p == nil {
+		return false
+	}
+	if err != nil {
+		if isConnectFailure(err) {
+			return p.retryOn("connect-failure")
+		}
+		return isIdempotent(method) && p.retryOn("reset")
+	}
+	if !isIdempotent(method) {
+		return false
+	}
+	status := resp.StatusCode()
+	if status == 503 && p.retryOn("503") {
+		return true
+	}
+	if status >= 500 && status < 600 && p.retryOn("5xx") {
+		return true
+	}
+	if p.retryOn("retriable-status-codes") {
+		for _, code := range p.StatusCodes {
+			if code == status {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backoff returns the jittered time to wait before the next attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := DefaultBackoffBase, DefaultBackoffMax
+	if p.BackoffBase.Duration > 0 {
+		base = p.BackoffBase.Duration
+	}
+	if p.BackoffMax.Duration > 0 {
+		max = p.BackoffMax.Duration
+	}
+	d := base << uint(attempt-1)
+	if d > max || d <= 0 {
+		d = max
+	}
+	// full jitter
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func isIdempotent(method string) bool {
+	for _, m := range idempotentMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectFailure checks if the error occurred before the request
+// was sent to the upstream application
+func isConnectFailure(err error) bool {
+	switch err {
+	case fasthttp.ErrDialTimeout, fasthttp.ErrNoFreeConns,
+		ErrCircuitOpen, ErrQueueFull, ErrQueueTimeout:
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+	return false
+}
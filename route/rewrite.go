@@ -0,0 +1,251 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rgumi/depoy/router"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	templateExpr = regexp.MustCompile(`\{([A-Za-z0-9_.\-]+)\}`)
+)
+
+// RewritePolicy rewrites the path, the query and the Host header of a request before
+// it is forwarded. Values may contain template expressions which are replaced by
+// attributes of the downstream request:
+//
+//	{method} {host} {path} {remote_ip} {query.<name>} {header.<name>} {cookie.<name>}
+//	{param.<name>} or {<name>} for parameters captured by a template or regex route
+type RewritePolicy struct {
+	// PathPattern is matched against the path. If it matches, the path is replaced by
+	// PathReplacement which may contain captures ($1, ${name}) and template expressions.
+	// If PathPattern is set, Rewrite of the route is not applied
+	PathPattern     string `json:"path_pattern,omitempty" yaml:"pathPattern,omitempty"`
+	PathReplacement string `json:"path_replacement,omitempty" yaml:"pathReplacement,omitempty"`
+	// RemoveQuery, RenameQuery (old: new) and AddQuery (name: value) are applied in this order
+	RemoveQuery []string          `json:"remove_query,omitempty" yaml:"removeQuery,omitempty"`
+	RenameQuery map[string]string `json:"rename_query,omitempty" yaml:"renameQuery,omitempty"`
+	AddQuery    map[string]string `json:"add_query,omitempty" yaml:"addQuery,omitempty"`
+	// Host of the upstream request. allowed: backend (host of the backend), client
+	// (host of the downstream request) or a fixed value which may contain template expressions
+	Host string `json:"host" yaml:"host" default:"backend"`
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks the configuration and prepares the RewritePolicy for usage
+func (p *RewritePolicy) Validate() error {
+	if p.PathPattern != "" {
+		pattern, err := regexp.Compile(p.PathPattern)
+		if err != nil {
+			return fmt.Errorf("Invalid path pattern of rewrite (%s)", p.PathPattern)
+		}
+		p.pattern = pattern
+	}
+	if p.Host == "" {
+		p.Host = "backend"
+	}
+	for oldName, newName := range p.RenameQuery {
+		if oldName == "" || newName == "" {
+			return fmt.Errorf("Names of renamed query parameters cannot be empty")
+		}
+	}
+	return nil
+}
+
+// keepsHost returns if the Host of the upstream request differs from the backend
+func (p *RewritePolicy) keepsHost() bool {
+	return p != nil && strings.ToLower(p.Host) != "backend"
+}
+
+// sendsHostHeader returns if the upstream request is sent to the address of the
+// backend with the Host of the downstream request or the rewritten Host
+func (r *Route) sendsHostHeader() bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.RewritePolicy.keepsHost()
+}
+
+// SetRewritePolicy validates and sets the RewritePolicy of the route.
+// If policy is nil, the policy of the route is removed
+func (r *Route) SetRewritePolicy(policy *RewritePolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	r.RewritePolicy = policy
+	r.mux.Unlock()
+	return nil
+}
+
+// expandTemplate replaces the template expressions of value
+// with the attributes of the request
+func expandTemplate(value string, ctx *fasthttp.RequestCtx) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+	params := router.Params(ctx)
+	return templateExpr.ReplaceAllStringFunc(value, func(expr string) string {
+		name := expr[1 : len(expr)-1]
+		switch {
+		case name == "method":
+			return string(ctx.Method())
+		case name == "host":
+			return string(ctx.Host())
+		case name == "path":
+			return string(ctx.Path())
+		case name == "remote_ip":
+			return ctx.RemoteIP().String()
+		case strings.HasPrefix(name, "query."):
+			return string(ctx.QueryArgs().Peek(name[len("query."):]))
+		case strings.HasPrefix(name, "header."):
+			return string(ctx.Request.Header.Peek(name[len("header."):]))
+		case strings.HasPrefix(name, "cookie."):
+			return string(ctx.Request.Header.Cookie(name[len("cookie."):]))
+		case strings.HasPrefix(name, "param."):
+			return params[name[len("param."):]]
+		}
+		if param, found := params[name]; found {
+			return param
+		}
+		return expr
+	})
+}
+
+// rewriteRequest applies the Rewrite of template and regex routes and the RewritePolicy
+// to the downstream request. All templates are expanded before the request is changed
+func (r *Route) rewriteRequest(ctx *fasthttp.RequestCtx) {
+	r.mux.RLock()
+	policy := r.RewritePolicy
+	r.mux.RUnlock()
+
+	path := ""
+	if r.Rewrite != "" && (r.Match == router.MatchTemplate || r.Match == router.MatchRegex) {
+		path = expandTemplate(r.Rewrite, ctx)
+	}
+	if policy == nil {
+		if path != "" {
+			ctx.URI().SetPath(path)
+		}
+		return
+	}
+
+	if policy.pattern != nil {
+		current := string(ctx.Path())
+		if match := policy.pattern.FindStringSubmatchIndex(current); match != nil {
+			replacement := expandTemplate(policy.PathReplacement, ctx)
+			path = string(policy.pattern.ExpandString(nil, replacement, current, match))
+		}
+	}
+	added := make(map[string]string, len(policy.AddQuery))
+	for name, value := range policy.AddQuery {
+		added[name] = expandTemplate(value, ctx)
+	}
+	host := ""
+	if lower := strings.ToLower(policy.Host); lower != "backend" && lower != "client" {
+		host = expandTemplate(policy.Host, ctx)
+	}
+
+	if path != "" {
+		ctx.URI().SetPath(path)
+	}
+	args := ctx.URI().QueryArgs()
+	for _, name := range policy.RemoveQuery {
+		args.Del(name)
+	}
+	for oldName, newName := range policy.RenameQuery {
+		if value := args.Peek(oldName); value != nil {
+			value = append([]byte(nil), value...)
+			args.Del(oldName)
+			args.SetBytesV(newName, value)
+		}
+	}
+	for name, value := range added {
+		args.Set(name, value)
+	}
+	if host != "" {
+		ctx.Request.Header.SetHost(host)
+		ctx.URI().SetHost(host)
+	}
+}
+
+// rewritesPrefix returns if the prefix of the path is replaced by Rewrite when the
+// request is forwarded. Template and regex routes are rewritten using their parameters
+func (r *Route) rewritesPrefix() bool {
+	if r.Rewrite == "" || r.Match == router.MatchTemplate || r.Match == router.MatchRegex {
+		return false
+	}
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.RewritePolicy == nil || r.RewritePolicy.pattern == nil
+}
+
+// RewritePreview is the upstream request a downstream request is rewritten to
+type RewritePreview struct {
+	Backend string            `json:"backend"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// PreviewRewrite returns the upstream request the downstream request would be
+// rewritten to including the forwarded headers and the request operations of the
+// HeaderPolicies. If backendName is empty, the next backend of the route is used
+func (r *Route) PreviewRewrite(ctx *fasthttp.RequestCtx, backendName string) (*RewritePreview, error) {
+	matched, params := router.MatchPath(r.Match, r.Prefix, string(ctx.Path()))
+	if !matched {
+		return nil, fmt.Errorf("Path %s is not matched by %s", ctx.Path(), r.Name)
+	}
+	router.SetParams(ctx, params)
+
+	var target *Backend
+	for _, backend := range r.Backends {
+		if backend.Name == backendName {
+			target = backend
+		}
+	}
+	if target == nil {
+		if backendName != "" {
+			return nil, fmt.Errorf("Unable to find backend %s", backendName)
+		}
+		var err error
+		if target, err = r.getNextBackend(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the same steps as for a forwarded request are applied
+	r.prepareRequest(ctx)
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx.Request.CopyTo(req)
+	delRequestHopHeader(req)
+	appendXForwardForHeader(req, ctx.RemoteAddr().String())
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+	req.URI().CopyTo(uri)
+	r.prepareAttempt(ctx, req, uri, target)
+
+	preview := &RewritePreview{
+		Backend: target.Name,
+		Method:  string(req.Header.Method()),
+		URL:     req.URI().String(),
+		Host:    string(req.URI().Host()),
+		Headers: make(map[string]string),
+	}
+	if r.sendsHostHeader() {
+		preview.URL = fmt.Sprintf("%s://%s%s", target.Addr.Scheme, target.Addr.Host, req.URI().RequestURI())
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		if string(key) != "Host" {
+			preview.Headers[string(key)] = string(value)
+		}
+	})
+	return preview, nil
+}
@@ -0,0 +1,201 @@
+package route
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rgumi/depoy/router"
+	"github.com/valyala/fasthttp"
+)
+
+func newRewriteCtx(uri string, params map[string]string) *fasthttp.RequestCtx {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.SetRequestURI(uri)
+	req.Header.SetHost("example.com")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.SetCookie("session", "abc")
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(req, &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, nil)
+	router.SetParams(ctx, params)
+	return ctx
+}
+
+func Test_ExpandTemplate(t *testing.T) {
+	ctx := newRewriteCtx("/users/42?page=2", map[string]string{"id": "42"})
+
+	tests := map[string]string{
+		"plain":                      "plain",
+		"{method}":                   "GET",
+		"{host}":                     "example.com",
+		"{path}":                     "/users/42",
+		"{remote_ip}":                "10.0.0.1",
+		"{query.page}":               "2",
+		"{query.missing}":            "",
+		"{header.x-tenant}":          "acme",
+		"{cookie.session}":           "abc",
+		"{param.id}":                 "42",
+		"/v2/{id}":                   "/v2/42",
+		"{unknown}":                  "{unknown}",
+		"{method}-{header.X-Tenant}": "GET-acme",
+	}
+	for template, expected := range tests {
+		if got := expandTemplate(template, ctx); got != expected {
+			t.Errorf("Template %s expanded to %q instead of %q", template, got, expected)
+		}
+	}
+}
+
+func Test_RewritePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    *Route
+		uri      string
+		params   map[string]string
+		expected string
+	}{
+		{
+			name:     "template route",
+			route:    &Route{Match: router.MatchTemplate, Rewrite: "/v2/users/{id}"},
+			uri:      "/users/42",
+			params:   map[string]string{"id": "42"},
+			expected: "/v2/users/42",
+		},
+		{
+			name: "regex with captures",
+			route: &Route{RewritePolicy: &RewritePolicy{
+				PathPattern: `^/api/(?P<version>v\d+)/(.*)$`, PathReplacement: "/${version}/$2",
+			}},
+			uri:      "/api/v1/items/7",
+			expected: "/v1/items/7",
+		},
+		{
+			name: "regex with template",
+			route: &Route{RewritePolicy: &RewritePolicy{
+				PathPattern: `^/api/(.*)$`, PathReplacement: "/{header.X-Tenant}/$1",
+			}},
+			uri:      "/api/items",
+			expected: "/acme/items",
+		},
+		{
+			name: "regex without match",
+			route: &Route{RewritePolicy: &RewritePolicy{
+				PathPattern: `^/admin/(.*)$`, PathReplacement: "/$1",
+			}},
+			uri:      "/api/items",
+			expected: "/api/items",
+		},
+	}
+	for _, test := range tests {
+		if test.route.RewritePolicy != nil {
+			if err := test.route.RewritePolicy.Validate(); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		ctx := newRewriteCtx(test.uri, test.params)
+		test.route.rewriteRequest(ctx)
+		if path := string(ctx.Path()); path != test.expected {
+			t.Errorf("%s: path was rewritten to %s instead of %s", test.name, path, test.expected)
+		}
+	}
+}
+
+func Test_RewriteQuery(t *testing.T) {
+	policy := &RewritePolicy{
+		RemoveQuery: []string{"debug"},
+		RenameQuery: map[string]string{"q": "query"},
+		AddQuery:    map[string]string{"tenant": "{header.X-Tenant}"},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := &Route{RewritePolicy: policy}
+	ctx := newRewriteCtx("/search?q=go&debug=1&page=2", nil)
+	r.rewriteRequest(ctx)
+
+	args := ctx.URI().QueryArgs()
+	tests := map[string]string{
+		"q":      "",
+		"debug":  "",
+		"query":  "go",
+		"page":   "2",
+		"tenant": "acme",
+	}
+	for name, expected := range tests {
+		if value := string(args.Peek(name)); value != expected {
+			t.Errorf("Query parameter %s is %q instead of %q", name, value, expected)
+		}
+	}
+
+	invalid := &RewritePolicy{RenameQuery: map[string]string{"q": ""}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Accepted rename of query parameter to an empty name")
+	}
+}
+
+func Test_RewriteHost(t *testing.T) {
+	tests := map[string]string{
+		"backend":                    "example.com",
+		"client":                     "example.com",
+		"{header.X-Tenant}.internal": "acme.internal",
+	}
+	for host, expected := range tests {
+		policy := &RewritePolicy{Host: host}
+		if err := policy.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		r := &Route{RewritePolicy: policy}
+		ctx := newRewriteCtx("/", nil)
+		r.rewriteRequest(ctx)
+		if got := string(ctx.Request.Header.Host()); got != expected {
+			t.Errorf("Host %s was rewritten to %s instead of %s", host, got, expected)
+		}
+		if keepsHost := policy.keepsHost(); keepsHost != (host != "backend") {
+			t.Errorf("Host %s keeps the host of the request: %v", host, keepsHost)
+		}
+	}
+}
+
+func Test_PreviewRewrite(t *testing.T) {
+	addr, _ := url.Parse("http://backend:8080")
+	backend := &Backend{
+		ID:   uuid.New(),
+		Name: "v1",
+		Addr: addr,
+		HeaderPolicy: &HeaderPolicy{
+			Request: &HeaderOperations{Set: map[string]string{"X-Backend": "{backend}"}},
+		},
+	}
+	r := &Route{
+		Name:     "test",
+		Match:    router.MatchPrefix,
+		Prefix:   "/api/",
+		Rewrite:  "/",
+		Backends: map[uuid.UUID]*Backend{backend.ID: backend},
+		HeaderPolicy: &HeaderPolicy{
+			Request: &HeaderOperations{Set: map[string]string{"X-Route": "{route}"}, Remove: []string{"X-Tenant"}},
+		},
+	}
+	preview, err := r.PreviewRewrite(newRewriteCtx("/api/items", nil), "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.URL != "http://backend:8080/items" {
+		t.Errorf("Unexpected url %s", preview.URL)
+	}
+	tests := map[string]string{
+		"X-Route":           "test",
+		"X-Backend":         "v1",
+		"X-Tenant":          "",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "example.com",
+		"Forwarded":         `for=10.0.0.1;host="example.com";proto=http`,
+	}
+	for name, expected := range tests {
+		if value := preview.Headers[name]; value != expected {
+			t.Errorf("Header %s of preview is %q instead of %q", name, value, expected)
+		}
+	}
+}
@@ -2,19 +2,24 @@ package route
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"net"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/valyala/fasthttp"
 
+	"github.com/rgumi/depoy/router"
 	"github.com/rgumi/depoy/upstreamclient"
 
 	"github.com/rgumi/depoy/conditional"
 	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/middleware"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
@@ -23,9 +28,15 @@ import (
 type Route struct {
 	Name                string
 	Prefix              string
+	Match               string
 	Methods             []string
 	Host                string
+	HostAliases         []string
 	Rewrite             string
+	RewritePolicy       *RewritePolicy
+	HeaderPolicy        *HeaderPolicy
+	TLSPolicy           *TLSPolicy
+	ClientCertPolicy    *ClientCertPolicy
 	CookieTTL           time.Duration
 	Strategy            *Strategy
 	HealthCheck         bool
@@ -36,6 +47,16 @@ type Route struct {
 	IdleTimeout         time.Duration
 	ScrapeInterval      time.Duration
 	Proxy               string
+	NoProxy             []string
+	Retry               *RetryPolicy
+	ConcurrencyLimit    *ConcurrencyLimit
+	RateLimit           *RateLimit
+	Mirrors             []*Mirror
+	TraceSampleRate     *float64
+	AccessLogSampleRate *float64
+	FailoverThreshold   uint8
+	DeploymentWindows   []*DeploymentWindow
+	FreezePeriods       []*FreezePeriod
 	cookieName          string
 	Backends            map[uuid.UUID]*Backend
 	Switchover          *Switchover
@@ -44,25 +65,38 @@ type Route struct {
 	NextTargetDistr     []*Backend
 	lenNextTargetDistr  int
 	killHealthCheck     chan int
+	activeTier          int
+	tierSelected        bool
+	events              []Event
+	history             []*SwitchoverHistory // only used if HistoryDir is empty
+	eventMux            sync.Mutex
 	mux                 sync.RWMutex
 }
 
 // New creates a new route-object with the provided config
 func New(
-	name, prefix, rewrite, host, proxy string,
+	name, prefix, match, rewrite, host, proxy string,
 	methods []string,
 	readTimeout, writeTimeout, idleTimeout, scrapeInterval, healthcheckInterval,
 	monitoringInterval, cookieTTL time.Duration,
 	doHealthCheck bool,
 ) (*Route, error) {
 
+	if match == "" {
+		match = router.MatchPrefix
+	}
+	match = strings.ToLower(match)
+	if err := router.ValidatePattern(match, prefix); err != nil {
+		return nil, err
+	}
 	// fix prefix if prefix does not end with /
-	if prefix[len(prefix)-1] != '/' {
+	if match == router.MatchPrefix && prefix[len(prefix)-1] != '/' {
 		prefix += "/"
 	}
 	route := &Route{
 		Name:                name,
 		Prefix:              prefix,
+		Match:               match,
 		Rewrite:             rewrite,
 		Methods:             methods,
 		Host:                host,
@@ -80,9 +114,12 @@ func New(
 		killHealthCheck:     make(chan int, 1),
 		CookieTTL:           cookieTTL,
 		Client: upstreamclient.NewUpstreamclient(readTimeout, writeTimeout, idleTimeout,
-			upstreamclient.MaxIdleConnsPerHost, upstreamclient.SkipTLSVerify,
+			upstreamclient.MaxIdleConnsPerHost, upstreamclient.TLSVerify,
 		),
 	}
+	if err := route.SetProxy(proxy, nil); err != nil {
+		return nil, err
+	}
 
 	if route.HealthCheck {
 		go route.RunHealthCheckOnBackends()
@@ -90,33 +127,159 @@ func New(
 	return route, nil
 }
 
+// Hosts returns the host and the host aliases of the route
+func (r *Route) Hosts() []string {
+	return append([]string{r.Host}, r.HostAliases...)
+}
+
+// SetProxy sets the proxy through which the backends of the route are reached.
+// Hosts matching an entry of noProxy are reached directly
+func (r *Route) SetProxy(proxy string, noProxy []string) error {
+	if err := r.Client.SetProxy(proxy, noProxy); err != nil {
+		return err
+	}
+	r.mux.Lock()
+	r.Proxy = proxy
+	r.NoProxy = noProxy
+	r.mux.Unlock()
+	return nil
+}
+
 func (r *Route) SetStrategy(strategy *Strategy) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.MetricsRepo != nil && (isABTest(r.Strategy) || isABTest(strategy)) {
+		// the metrics of the variants belong to the experiment which is replaced
+		r.MetricsRepo.ResetVariants(r.Name)
+	}
 	r.Strategy = strategy
 }
 
+func isABTest(strategy *Strategy) bool {
+	return strategy != nil && strategy.Type == "abtest"
+}
+
+// GetHandler returns the handler of the route which applies the
+// policies of the route before the request is handed to the strategy
 func (r *Route) GetHandler() fasthttp.RequestHandler {
 	if r.Strategy == nil {
 		panic(fmt.Errorf("No strategy is set for %s", r.Name))
 	}
 
-	return r.Strategy.Handler
+	return func(ctx *fasthttp.RequestCtx) {
+		r.mux.RLock()
+		rateLimit := r.RateLimit
+		strategy := r.Strategy
+		mirrors := r.Mirrors
+		sampleRate := r.TraceSampleRate
+		logSampleRate := r.AccessLogSampleRate
+		clientCertPolicy := r.ClientCertPolicy
+		r.mux.RUnlock()
+		// applied last so that every response (including errors) is covered
+		defer r.applyResponseHeaders(ctx)
+		r.startSpan(ctx, strategy, sampleRate)
+		r.logRoute(ctx, logSampleRate)
+
+		if !r.authorizeClient(ctx, clientCertPolicy) {
+			return
+		}
+
+		if rateLimit != nil {
+			allowed, remaining, reset := rateLimit.Allow(ctx)
+			if !allowed {
+				r.rejectRateLimited(ctx, rateLimit, reset)
+				return
+			}
+			if remaining >= 0 {
+				// the response headers are overwritten by the upstream response
+				defer rateLimit.setHeaders(ctx, remaining, reset)
+			}
+		}
+		r.prepareRequest(ctx)
+		r.startMirrors(ctx, mirrors)
+		strategy.Handler(ctx)
+	}
+}
+
+// SetRetryPolicy validates and sets the RetryPolicy of the route.
+// If policy is nil, failed requests are not retried
+func (r *Route) SetRetryPolicy(policy *RetryPolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	r.Retry = policy
+	r.mux.Unlock()
+	return nil
+}
+
+// SetRateLimit validates and sets the RateLimit of the route.
+// If rateLimit is nil, the rate limit of the route is removed
+func (r *Route) SetRateLimit(rateLimit *RateLimit) error {
+	if rateLimit != nil {
+		if err := rateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	r.RateLimit = rateLimit
+	r.mux.Unlock()
+	return nil
+}
+
+// SetFailoverThreshold sets the healthy capacity of a priority tier in percent
+// below which its traffic spills over to the next tier
+func (r *Route) SetFailoverThreshold(threshold uint8) error {
+	if threshold > 100 {
+		return fmt.Errorf("FailoverThreshold must be between 0 and 100")
+	}
+	r.mux.Lock()
+	r.FailoverThreshold = threshold
+	r.mux.Unlock()
+	return nil
+}
+
+// SetConcurrencyLimit validates, resets and sets the ConcurrencyLimit of the route.
+// If limit is nil, the concurrency of the route is not limited
+func (r *Route) SetConcurrencyLimit(limit *ConcurrencyLimit) error {
+	if limit != nil {
+		if err := limit.Validate(); err != nil {
+			return err
+		}
+		limit.init()
+	}
+	r.mux.Lock()
+	r.ConcurrencyLimit = limit
+	r.mux.Unlock()
+	return nil
+}
+
+func (r *Route) rejectRateLimited(ctx *fasthttp.RequestCtx, rateLimit *RateLimit, reset time.Duration) {
+	log.Debugf("Rejected request of %s to %s due to rate limit", ctx.RemoteIP(), r.Name)
+	m := metrics.AcquireMetrics()
+	m.Route = r.Name
+	m.RequestMethod = string(ctx.Method())
+	m.ResponseStatus = 429
+	m.RateLimited = true
+	r.MetricsRepo.InChannel <- m
+
+	middleware.Error(ctx, "Too Many Requests", 429)
+	rateLimit.setHeaders(ctx, 0, reset)
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
 }
 
 func (r *Route) updateWeights() {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
-	var sum uint8
-	k, i := 0, 0
-	listWeights := make([]uint8, len(r.Backends))
-	activeBackends := []*Backend{}
+	sum, k := 0, 0
+	activeBackends := r.selectActiveBackends()
+	listWeights := make([]uint8, len(activeBackends)+1) // GGT requires at least one entry
 
-	for _, backend := range r.Backends {
-		if backend.Active {
-			listWeights[i] = backend.Weigth
-			activeBackends = append(activeBackends, backend)
-			i++
-		}
+	for i, backend := range activeBackends {
+		listWeights[i] = backend.Weigth
 	}
 	// find ggt to reduce list length
 	ggt := GGT(listWeights) // if 0, return 0
@@ -124,7 +287,7 @@ func (r *Route) updateWeights() {
 
 	if ggt > 0 {
 		for _, weight := range listWeights {
-			sum += weight / ggt
+			sum += int(weight / ggt)
 		}
 		distr := make([]*Backend, sum)
 
@@ -143,6 +306,56 @@ func (r *Route) updateWeights() {
 	r.lenNextTargetDistr = len(r.NextTargetDistr)
 }
 
+// selectActiveBackends returns the active backends of the highest priority tier
+// which has enough healthy capacity. If a tier does not reach the FailoverThreshold,
+// its traffic spills over to the next tier. The caller must hold the lock
+func (r *Route) selectActiveBackends() []*Backend {
+	tiers := make(map[int][]*Backend)
+	priorities := []int{}
+	for _, backend := range r.Backends {
+		if _, found := tiers[backend.Priority]; !found {
+			priorities = append(priorities, backend.Priority)
+		}
+		tiers[backend.Priority] = append(tiers[backend.Priority], backend)
+	}
+	sort.Ints(priorities)
+
+	selected := []*Backend{}
+	lowestTier := 0
+	for _, priority := range priorities {
+		var totalWeight, activeWeight int
+		for _, backend := range tiers[priority] {
+			totalWeight += int(backend.Weigth)
+			if backend.Active {
+				activeWeight += int(backend.Weigth)
+				selected = append(selected, backend)
+			}
+		}
+		lowestTier = priority
+		// healthy capacity of the tier in percent
+		if activeWeight > 0 && activeWeight*100 >= int(r.FailoverThreshold)*totalWeight {
+			break
+		}
+	}
+
+	if len(priorities) > 0 && !r.tierSelected {
+		// traffic is initially restricted to the highest priority tier
+		r.activeTier = priorities[0]
+		r.tierSelected = true
+	}
+	if len(priorities) > 0 && lowestTier != r.activeTier {
+		if lowestTier > r.activeTier {
+			r.emitEvent("FailoverTierChanged",
+				"Insufficient healthy capacity. Traffic spills over to priority tier %d", lowestTier)
+		} else {
+			r.emitEvent("FailoverTierChanged",
+				"Sufficient healthy capacity. Traffic is restricted to priority tier %d", lowestTier)
+		}
+		r.activeTier = lowestTier
+	}
+	return selected
+}
+
 func (r *Route) getNextBackend() (*Backend, error) {
 
 	if r.lenNextTargetDistr == 0 {
@@ -153,6 +366,25 @@ func (r *Route) getNextBackend() (*Backend, error) {
 	return backend, nil
 }
 
+// getNextBackendExcluding selects the next backend based on the weights
+// but ignores all backends that are provided in exclude
+func (r *Route) getNextBackendExcluding(exclude []*Backend) (*Backend, error) {
+	candidates := []*Backend{}
+outer:
+	for _, backend := range r.NextTargetDistr {
+		for _, excluded := range exclude {
+			if backend == excluded {
+				continue outer
+			}
+		}
+		candidates = append(candidates, backend)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("No other backend is active")
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
 // Reload is required if the route is changed (reload config).
 // when a new backend is registerd reload handles the initial tasks
 // like monitoring and healthcheck
@@ -165,6 +397,8 @@ func (r *Route) Reload() {
 		panic(fmt.Errorf("MetricsRepo of %s cannot be nil", r.Name))
 	}
 	for _, backend := range r.Backends {
+		// the gauge may have been removed together with a replaced route
+		backend.CircuitBreaker.publish()
 		if backend.AlertChan == nil {
 			if r.HealthCheck {
 				mustHaveCondition := conditional.NewCondition(
@@ -222,7 +456,7 @@ func (r *Route) AddBackend(
 	name string, addr, scrapeURL, healthCheckURL *url.URL,
 	scrapeMetrics []string,
 	metricsThresholds []*conditional.Condition,
-	weight uint8) (uuid.UUID, error) {
+	weight uint8, circuitBreaker *CircuitBreaker) (uuid.UUID, error) {
 
 	backend, err := NewBackend(
 		name, addr, scrapeURL, healthCheckURL, scrapeMetrics, metricsThresholds, weight)
@@ -230,6 +464,8 @@ func (r *Route) AddBackend(
 		return uuid.UUID{}, err
 	}
 	backend.updateWeigth = r.updateWeights
+	backend.onAlarm = r.onBackendAlarm
+	backend.CircuitBreaker = circuitBreaker
 
 	if r.HealthCheck {
 		backend.Active = false
@@ -243,6 +479,7 @@ func (r *Route) AddBackend(
 		}
 	}
 
+	backend.CircuitBreaker.init(r.Name, backend.ID.String())
 	log.Warnf("Added Backend %v to Route %s", backend.ID, r.Name)
 	r.Backends[backend.ID] = backend
 
@@ -280,8 +517,26 @@ func (r *Route) AddExistingBackend(backend *Backend) (uuid.UUID, error) {
 	}
 
 	newBackend.updateWeigth = r.updateWeights
+	newBackend.onAlarm = r.onBackendAlarm
 	newBackend.ActiveAlerts = make(map[string]metrics.Alert)
 	newBackend.killChan = make(chan int, 1)
+	newBackend.CircuitBreaker = backend.CircuitBreaker
+	newBackend.CircuitBreaker.init(r.Name, newBackend.ID.String())
+	if backend.ConcurrencyLimit != nil {
+		if err := backend.ConcurrencyLimit.Validate(); err != nil {
+			return uuid.UUID{}, err
+		}
+		backend.ConcurrencyLimit.init()
+		newBackend.ConcurrencyLimit = backend.ConcurrencyLimit
+	}
+	newBackend.HeaderPolicy = backend.HeaderPolicy
+	if backend.TLSPolicy != nil {
+		if err := backend.TLSPolicy.Validate(); err != nil {
+			return uuid.UUID{}, err
+		}
+		newBackend.TLSPolicy = backend.TLSPolicy
+	}
+	newBackend.Priority = backend.Priority
 
 	log.Warnf("Added Backend %v to Route %s", newBackend.ID, r.Name)
 	r.Backends[newBackend.ID] = newBackend
@@ -300,14 +555,23 @@ func (r *Route) RemoveBackend(backendID uuid.UUID) error {
 
 	if r.Switchover != nil {
 		if r.Switchover.From.ID == backendID || r.Switchover.To.ID == backendID {
-			return fmt.Errorf("Cannot deleted backend %v with switchover %d associated with it",
+			return fmt.Errorf("Cannot deleted backend %v with switchover %v associated with it",
 				backendID, r.Switchover.ID,
 			)
 		}
 	}
+	r.mux.RLock()
+	for _, mirror := range r.Mirrors {
+		if mirror.backend != nil && mirror.backend.ID == backendID {
+			r.mux.RUnlock()
+			return fmt.Errorf("Cannot delete backend %v which is the target of a mirror", backendID)
+		}
+	}
+	r.mux.RUnlock()
 	if r.MetricsRepo != nil {
 		r.MetricsRepo.RemoveBackend(backendID)
 	}
+	r.Backends[backendID].CircuitBreaker.remove()
 	r.Backends[backendID].Stop()
 	delete(r.Backends, backendID)
 	return nil
@@ -330,8 +594,14 @@ func (r *Route) healthCheck(backend *Backend) bool {
 	m.BackendID = backend.ID
 	m.Route = r.Name
 	m.RequestMethod = string(req.Header.Method())
-	m.DownstreamAddr = "depoy-healthcheck"
-	resp, err := r.Client.Send(req, m)
+	m.DownstreamAddr = metrics.HealthCheckAddr
+	var resp *fasthttp.Response
+	var err error
+	if tlsConfig := backend.tlsConfig(); tlsConfig != nil && backend.Healthcheckurl.Scheme == "https" {
+		resp, err = r.Client.SendToAddrWithTLS(req, backend.Healthcheckurl.Host, true, tlsConfig, m, 0)
+	} else {
+		resp, err = r.Client.Send(req, m)
+	}
 	fasthttp.ReleaseRequest(req)
 	if err != nil {
 		log.Debugf("Healthcheck for %v failed due to %v", backend.ID, err)
@@ -372,19 +642,65 @@ func (r *Route) RunHealthCheckOnBackends() {
 func (r *Route) StartSwitchOver(
 	from, to string,
 	conditions []*conditional.Condition,
-	timeout time.Duration, allowedFailures int,
+	steps []*SwitchoverStep,
+	timeout, bakePeriod time.Duration, startAt time.Time, allowedFailures int,
+	minRequests, maxInconclusive int, inconclusiveAction string,
+	weightChange uint8, force, rollback bool) (*Switchover, error) {
+
+	switch strings.ToLower(inconclusiveAction) {
+	case "", "pause", "fail":
+	default:
+		return nil, fmt.Errorf("Unsupported inconclusive action (%s). Allowed: pause, fail", inconclusiveAction)
+	}
+
+	switchover, err := r.newSwitchOver(
+		from, to, conditions, steps, timeout, startAt, allowedFailures, weightChange, force, rollback)
+	if err != nil {
+		return nil, err
+	}
+	switchover.BakePeriod = bakePeriod
+	switchover.StartAt = startAt
+	switchover.MinRequests = minRequests
+	switchover.MaxInconclusive = maxInconclusive
+	switchover.InconclusiveAction = inconclusiveAction
+
+	r.Switchover = switchover
+	go switchover.Start()
+
+	return switchover, nil
+}
+
+// newSwitchOver validates the parameters and returns a new switchover
+// which is neither started nor set for the route. The route is not changed,
+// if force is set, the strategy and weights are overwritten when the switchover begins
+func (r *Route) newSwitchOver(
+	from, to string,
+	conditions []*conditional.Condition,
+	steps []*SwitchoverStep,
+	timeout time.Duration, startAt time.Time, allowedFailures int,
 	weightChange uint8, force, rollback bool) (*Switchover, error) {
 
 	var fromBackend, toBackend *Backend
+	var canary *Strategy
 
 	// check if a switchover is already active
 	// only one switchover is allowed per route at a time
 	if r.Switchover != nil {
-		if r.Switchover.Status == "Running" {
+		if r.Switchover.isActive() {
 			return nil, fmt.Errorf("Only one switchover can be active per route")
 		}
 	}
 
+	// new switchovers cannot be started within a freeze period
+	start := time.Now()
+	if startAt.After(start) {
+		start = startAt
+	}
+	if period := r.frozen(start); period != nil {
+		return nil, fmt.Errorf("Switchovers are frozen until %s (%s)",
+			period.End.Format(time.RFC3339), period.Reason)
+	}
+
 	if from == "" {
 		// select an existing backend
 		for _, backend := range r.Backends {
@@ -414,18 +730,12 @@ forward:
 	}
 
 	if force {
-		// Overwrite the current Strategy with CanaryStrategy
+		// the current Strategy is overwritten with CanaryStrategy when the switchover begins
 		strategy, err := NewCanaryStrategy(r)
 		if err != nil {
 			return nil, err
 		}
-		r.SetStrategy(strategy)
-
-		// set initial weights
-		fromBackend.Weigth = 100 - weightChange
-		toBackend.Weigth = weightChange
-
-		r.updateWeights()
+		canary = strategy
 
 	} else {
 		// The Strategy must be canary (sticky or slippery) because otherwise
@@ -437,18 +747,22 @@ forward:
 	}
 
 	switchover, err := NewSwitchover(
-		fromBackend, toBackend, r, conditions, timeout, allowedFailures, weightChange, rollback)
-
+		fromBackend, toBackend, r, conditions, steps, timeout, allowedFailures, weightChange, rollback)
 	if err != nil {
 		return nil, err
 	}
-
-	r.Switchover = switchover
-	go switchover.Start()
-
+	switchover.canary = canary
 	return switchover, nil
 }
 
+// onBackendAlarm is called if an alert of a backend of the route is alarming
+func (r *Route) onBackendAlarm(backend *Backend, alert metrics.Alert) {
+	r.flipBackOnAlarm(backend, alert)
+	if switchover := r.Switchover; switchover != nil && switchover.To == backend {
+		switchover.onAlarm(alert)
+	}
+}
+
 // RemoveSwitchOver stops the switchover process and leaves the weights as they are last
 func (r *Route) RemoveSwitchOver() {
 	if r.Switchover != nil {
@@ -460,47 +774,160 @@ func (r *Route) RemoveSwitchOver() {
 
 // HTTPDo accepts a request, target and the return-function
 // it sends the request to the target and
-// the response of the target is then handed to the return-function
+// the response of the target is then handed to the return-function.
+// If a RetryPolicy is configured, failed attempts are retried. If the strategy
+// selected the target by weight, retries are forwarded to another weighted backend
+// of the route if possible. If pinned is set, the target was selected explicitly
+// (e. g. by a routing header or a variant) and all attempts are sent to it.
+// The HeaderPolicies are applied to the request of each attempt
 func (r *Route) HTTPDo(
+	ctx *fasthttp.RequestCtx,
 	req *fasthttp.Request,
 	target *Backend,
-	returnResp func(*fasthttp.Response)) error {
+	pinned bool,
+	returnResp func(*fasthttp.Response, *Backend)) (err error) {
 
-	m := metrics.AcquireMetrics()
-	m.Route = r.Name
-	m.BackendID = target.ID
-	m.RequestMethod = string(req.Header.Method())
-	m.DSContentLength = int64(req.Header.ContentLength())
+	if err = r.ConcurrencyLimit.Acquire(); err != nil {
+		return err
+	}
+	start := time.Now()
+	defer func() {
+		r.ConcurrencyLimit.Release(time.Since(start), err != nil)
+	}()
+
+	method := string(req.Header.Method())
+	attempts := r.Retry.maxAttempts()
 
+	// each attempt may be sent to another backend, therefore
+	// the uri needs to be formatted based on the original one
+	origURI := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(origURI)
+	req.URI().CopyTo(origURI)
 	uri := fasthttp.AcquireURI()
 	defer fasthttp.ReleaseURI(uri)
-	req.URI().CopyTo(uri)
+	// the header policy of the previous backend must not be sent to the next one
+	var origHeader *fasthttp.RequestHeader
+	if attempts > 1 {
+		origHeader = new(fasthttp.RequestHeader)
+		req.Header.CopyTo(origHeader)
+	}
+
+	tried := make([]*Backend, 0, attempts)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			origHeader.CopyTo(&req.Header)
+		}
+		origURI.CopyTo(uri)
+		r.prepareAttempt(ctx, req, uri, target)
+
+		resp, err := r.send(ctx, req, target, attempt)
+		if attempt >= attempts || !r.Retry.shouldRetry(method, resp, err) {
+			ctx.SetUserValue(backendKey, target)
+			if err != nil {
+				return err
+			}
+			defer fasthttp.ReleaseResponse(resp)
+			returnResp(resp, target)
+			return nil
+		}
+		if resp != nil {
+			fasthttp.ReleaseResponse(resp)
+		}
+		log.Debugf("Attempt %d of request to %v of %s failed. Retrying", attempt, target.ID, r.Name)
+
+		if !pinned {
+			tried = append(tried, target)
+			if next, err := r.getNextBackendExcluding(tried); err == nil {
+				target = next
+			}
+		}
+		time.Sleep(r.Retry.backoff(attempt))
+	}
+}
+
+// prepareRequest adds the forwarded headers to the downstream request and rewrites it
+// before it is handed to the strategy
+func (r *Route) prepareRequest(ctx *fasthttp.RequestCtx) {
+	addForwardedHeaders(ctx)
+	r.rewriteRequest(ctx)
+}
+
+// prepareAttempt applies the header policies and the address of the target
+// to the upstream request of an attempt. uri is the uri of the downstream request
+func (r *Route) prepareAttempt(ctx *fasthttp.RequestCtx, req *fasthttp.Request, uri *fasthttp.URI, target *Backend) {
+	r.applyRequestHeaders(ctx, req, target)
 	r.formateURI(uri, target)
 	req.SetRequestURI(uri.String())
-	resp, err := r.Client.Send(req, m)
+}
+
+// send executes a single attempt of a request to the target
+// and records the outcome in the MetricsRepository and a client span
+func (r *Route) send(
+	ctx *fasthttp.RequestCtx, req *fasthttp.Request,
+	target *Backend, attempt int) (resp *fasthttp.Response, err error) {
+
+	span := r.startClientSpan(ctx, req, target, attempt)
+	defer func() {
+		finishClientSpan(span, resp, err)
+	}()
+
+	if err := target.ConcurrencyLimit.Acquire(); err != nil {
+		return nil, err
+	}
+	if !target.CircuitBreaker.Allow() {
+		target.ConcurrencyLimit.Release(0, false)
+		return nil, ErrCircuitOpen
+	}
+	m := metrics.AcquireMetrics()
+	m.Route = r.Name
+	m.BackendID = target.ID
+	m.RequestMethod = string(req.Header.Method())
+	m.DSContentLength = int64(req.Header.ContentLength())
+	m.Attempt = attempt
+	m.Variant = r.variantOf(req)
+	m.RequestID = string(req.Header.Peek(middleware.RequestIDHeader))
+
+	start := time.Now()
+	resp, err = r.sendUpstream(req, target, m, r.Retry.perTryTimeout())
+	latency := time.Since(start)
+	logAttempt(ctx, target, attempt, latency)
+	target.CircuitBreaker.Report(resp, err, latency)
+	target.ConcurrencyLimit.Release(latency, err != nil || resp.StatusCode() >= 500)
 	if err != nil {
 		m.ResponseStatus = 600
 		m.ContentLength = -1
 		r.MetricsRepo.InChannel <- m
-		return err
+		return nil, err
 	}
-	defer fasthttp.ReleaseResponse(resp)
-	returnResp(resp)
 	m.ResponseStatus = resp.StatusCode()
 	m.ContentLength = int64(resp.Header.ContentLength())
 	r.MetricsRepo.InChannel <- m
-	return nil
+	return resp, nil
+}
+
+// sendUpstream sends the request to the backend. If the Host of the request
+// is not the host of the backend, it is sent to the address of the backend
+func (r *Route) sendUpstream(
+	req *fasthttp.Request, target *Backend,
+	m *metrics.Metrics, timeout time.Duration) (*fasthttp.Response, error) {
+
+	if tlsConfig := target.tlsConfig(); tlsConfig != nil || r.sendsHostHeader() {
+		return r.Client.SendToAddrWithTLS(req, target.Addr.Host, target.Addr.Scheme == "https", tlsConfig, m, timeout)
+	}
+	return r.Client.SendWithTimeout(req, m, timeout)
 }
 
 // HTTPReturn takes a ctx and returns a functions that accepts an upstream response
-// which is then copied to the ctx response
+// which is then copied to the ctx response. If a cookie is provided, it is
+// set to the backend which actually served the response
 func HTTPReturn(
 	ctx *fasthttp.RequestCtx,
-	c *fasthttp.Cookie) func(resp *fasthttp.Response) {
+	c *fasthttp.Cookie) func(resp *fasthttp.Response, target *Backend) {
 
-	return func(resp *fasthttp.Response) {
+	return func(resp *fasthttp.Response, target *Backend) {
 		resp.Header.CopyTo(&ctx.Response.Header)
 		if c != nil {
+			c.SetValue(target.ID.String())
 			ctx.Response.Header.SetCookie(c)
 		}
 		ctx.SetStatusCode(resp.StatusCode())
@@ -509,21 +936,45 @@ func HTTPReturn(
 	}
 }
 
+// cookiePath returns the path of the session cookie
+func (r *Route) cookiePath() string {
+	if r.Match == router.MatchTemplate || r.Match == router.MatchRegex {
+		return "/"
+	}
+	return r.Prefix
+}
+
 func (r *Route) formateURI(uri *fasthttp.URI, backend *Backend) {
 	uri.SetScheme(backend.Addr.Scheme)
-	uri.SetHost(backend.Addr.Host)
-	if r.Rewrite != "" {
+	if !r.sendsHostHeader() {
+		uri.SetHost(backend.Addr.Host)
+	}
+	if r.rewritesPrefix() {
 		uri.SetPath(strings.Replace(string(uri.Path()), r.Prefix, r.Rewrite, 1))
 	}
 }
 
+// handleError writes the error response for an error that occurred
+// while forwarding the request to the upstream
+func handleError(ctx *fasthttp.RequestCtx, err error) {
+	msg, code := handleNetError(err)
+	middleware.Error(ctx, msg, code)
+	// ctx.Error resets the response, so the header must be set afterwards
+	if err == ErrQueueFull || err == ErrQueueTimeout {
+		ctx.Response.Header.Set("Retry-After", RetryAfter)
+	}
+}
+
 func handleNetError(err error) (string, int) {
+	if err == ErrCircuitOpen || err == ErrQueueFull || err == ErrQueueTimeout {
+		return err.Error(), 503
+	}
+	if timeoutErr, ok := err.(interface{ Timeout() bool }); ok && timeoutErr.Timeout() {
+		return err.Error(), 504
+	}
 	netErr, ok := err.(net.Error)
 	if !ok {
 		return err.Error(), 500
 	}
-	if netErr.Timeout() {
-		return netErr.Error(), 504
-	}
 	return netErr.Error(), 502
 }
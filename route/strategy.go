@@ -6,16 +6,39 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rgumi/depoy/middleware"
+	"github.com/rgumi/depoy/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 )
 
+var (
+	// shadowMaxInFlight is the maximal amount of concurrent requests to the
+	// backend of a shadow strategy. Requests above are not sent to the shadow
+	shadowMaxInFlight = 100
+)
+
 type Strategy struct {
-	Type        string                         `json:"type" yaml:"type" validate:"empty=false"`
-	HeaderName  string                         `json:"header_name,omitempty" yaml:"headerName,omitempty"`
-	HeaderValue string                         `json:"header_value,omitempty" yaml:"headerValue,omitempty"`
-	Target      string                         `json:"target_backend,omitempty" yaml:"targetBackend,omitempty"`
-	Handler     func(ctx *fasthttp.RequestCtx) `json:"-" yaml:"-"`
+	Type        string `json:"type" yaml:"type" validate:"empty=false"`
+	HeaderName  string `json:"header_name,omitempty" yaml:"headerName,omitempty"`
+	HeaderValue string `json:"header_value,omitempty" yaml:"headerValue,omitempty"`
+	Target      string `json:"target_backend,omitempty" yaml:"targetBackend,omitempty"`
+	// bluegreen: all traffic is forwarded to Active. Preview is reachable using
+	// PreviewHost, PreviewPrefix or HeaderName
+	Active        string `json:"active_backend,omitempty" yaml:"activeBackend,omitempty"`
+	Preview       string `json:"preview_backend,omitempty" yaml:"previewBackend,omitempty"`
+	PreviewHost   string `json:"preview_host,omitempty" yaml:"previewHost,omitempty"`
+	PreviewPrefix string `json:"preview_prefix,omitempty" yaml:"previewPrefix,omitempty"`
+	// FlipBackAfter is the duration after a flip in which an alarm of Active flips back
+	FlipBackAfter util.ConfigDuration `json:"flip_back_after" yaml:"flipBackAfter"`
+	// abtest: users are bucketed by a stable identifier (header, cookie or jwt)
+	// into the variants. The variant is sent to the backend in VariantHeader
+	Variants      []*Variant                     `json:"variants,omitempty" yaml:"variants,omitempty"`
+	BucketBy      string                         `json:"bucket_by,omitempty" yaml:"bucketBy,omitempty"`
+	BucketKey     string                         `json:"bucket_key,omitempty" yaml:"bucketKey,omitempty"`
+	VariantHeader string                         `json:"variant_header,omitempty" yaml:"variantHeader,omitempty"`
+	Handler       func(ctx *fasthttp.RequestCtx) `json:"-" yaml:"-"`
+	flippedAt     time.Time
 }
 
 func (s *Strategy) Validate(newRoute *Route) (err error) {
@@ -37,6 +60,20 @@ func (s *Strategy) Validate(newRoute *Route) (err error) {
 			return fmt.Errorf("Required parameter are missing")
 		}
 
+	case "bluegreen":
+		if newRoute == nil || s.Active == "" || s.Preview == "" {
+			return fmt.Errorf("Required parameter are missing")
+		}
+		if s.Active == s.Preview {
+			return fmt.Errorf("Active and Preview cannot be the same backend")
+		}
+
+	case "abtest":
+		if newRoute == nil {
+			return fmt.Errorf("Parameter route cannot be nil")
+		}
+		return validateVariants(s.Variants, s.BucketBy, s.BucketKey)
+
 	default:
 		return fmt.Errorf("Unsupported strategy type (%s)", t)
 	}
@@ -63,6 +100,19 @@ func (s *Strategy) Copy(newRoute *Route) error {
 		strat, err := NewHeaderStrategy(
 			newRoute, s.HeaderName, s.HeaderValue, s.Target)
 
+		if err != nil {
+			return err
+		}
+		newRoute.SetStrategy(strat)
+	case "bluegreen":
+		strat, err := NewBlueGreenStrategy(newRoute, s.Active, s.Preview, s.PreviewHost,
+			s.PreviewPrefix, s.HeaderName, s.HeaderValue, s.FlipBackAfter.Duration)
+		if err != nil {
+			return err
+		}
+		newRoute.SetStrategy(strat)
+	case "abtest":
+		strat, err := NewABTestStrategy(newRoute, s.Variants, s.BucketBy, s.BucketKey, s.VariantHeader)
 		if err != nil {
 			return err
 		}
@@ -134,6 +184,90 @@ func NewShadowStrategy(r *Route, shadowBackend string) (*Strategy, error) {
 	}, nil
 }
 
+// NewBlueGreenStrategy returns a strategy which forwards all traffic to the active
+// backend. The preview backend is only reachable using the preview host, the
+// preview prefix or the header (if headerValue is empty, any value matches)
+func NewBlueGreenStrategy(
+	r *Route, activeBackend, previewBackend, previewHost, previewPrefix,
+	headerName, headerValue string, flipBackAfter time.Duration) (*Strategy, error) {
+
+	var active, preview *Backend
+
+	if r == nil || activeBackend == "" || previewBackend == "" {
+		return nil, fmt.Errorf("Required parameter are missing")
+	}
+
+	for _, backend := range r.Backends {
+		if backend.Name == activeBackend {
+			active = backend
+		} else if backend.Name == previewBackend {
+			preview = backend
+		}
+	}
+
+	if active == nil || preview == nil {
+		return nil, fmt.Errorf("Unable to find the provided backends")
+	}
+	// the preview must not be selected by getNextBackend (e. g. on retries)
+	active.UpdateWeight(100)
+	preview.UpdateWeight(0)
+
+	return &Strategy{
+		Type:          "bluegreen",
+		Active:        activeBackend,
+		Preview:       previewBackend,
+		PreviewHost:   previewHost,
+		PreviewPrefix: previewPrefix,
+		HeaderName:    headerName,
+		HeaderValue:   headerValue,
+		FlipBackAfter: util.ConfigDuration{Duration: flipBackAfter},
+		Handler:       BlueGreenHandler(r, active, preview, headerName, headerValue),
+	}, nil
+}
+
+// NewABTestStrategy returns a strategy which assigns users deterministically to the
+// variants. Users are identified by bucketBy (header, cookie or jwt) and bucketKey
+// (the name of the header or cookie)
+func NewABTestStrategy(
+	r *Route, variants []*Variant, bucketBy, bucketKey, variantHeader string) (*Strategy, error) {
+
+	if r == nil {
+		return nil, fmt.Errorf("Required parameter are missing")
+	}
+	if err := validateVariants(variants, bucketBy, bucketKey); err != nil {
+		return nil, err
+	}
+	if variantHeader == "" {
+		variantHeader = DefaultVariantHeader
+	}
+
+	newVariants := make([]*Variant, len(variants))
+	for i, variant := range variants {
+		newVariants[i] = &Variant{
+			Name:       variant.Name,
+			Backend:    variant.Backend,
+			Percentage: variant.Percentage,
+		}
+		for _, backend := range r.Backends {
+			if backend.Name == variant.Backend {
+				newVariants[i].backend = backend
+			}
+		}
+		if newVariants[i].backend == nil {
+			return nil, fmt.Errorf("Unable to find backend %s of variant %s", variant.Backend, variant.Name)
+		}
+	}
+
+	return &Strategy{
+		Type:          "abtest",
+		Variants:      newVariants,
+		BucketBy:      strings.ToLower(bucketBy),
+		BucketKey:     bucketKey,
+		VariantHeader: variantHeader,
+		Handler:       ABTestHandler(r, newVariants, strings.ToLower(bucketBy), bucketKey, variantHeader),
+	}, nil
+}
+
 // CanaryHandler uses a Canary Strategy and selects a backend for forwarding
 // based on its weight. CanaryHandler also sets a session cookie so that all
 // following requests are forwarded to the same backend
@@ -160,13 +294,12 @@ func CanaryHandler(r *Route) func(ctx *fasthttp.RequestCtx) {
 		target, err = r.getNextBackend()
 		if err != nil {
 			log.Debugf("Could not get next backend: %v", err)
-			ctx.Error("No Upstream Host Available", 503)
+			middleware.Error(ctx, "No Upstream Host Available", 503)
 			return
 		}
 		log.Debugf("Setting new routeCookie for %v", target.ID)
 		c.SetKey(r.cookieName)
-		c.SetValue(target.ID.String())
-		c.SetPath(r.Prefix)
+		c.SetPath(r.cookiePath())
 		if r.CookieTTL > 0 {
 			c.SetExpire(time.Now().Add(r.CookieTTL))
 		}
@@ -178,8 +311,8 @@ func CanaryHandler(r *Route) func(ctx *fasthttp.RequestCtx) {
 		ctx.Request.CopyTo(req)
 		appendXForwardForHeader(req, ctx.RemoteAddr().String())
 		delRequestHopHeader(req)
-		if err = r.HTTPDo(req, target, HTTPReturn(ctx, c)); err != nil {
-			ctx.Error(handleNetError(err))
+		if err = r.HTTPDo(ctx, req, target, false, HTTPReturn(ctx, c)); err != nil {
+			handleError(ctx, err)
 		}
 	}
 }
@@ -197,8 +330,8 @@ func HeaderHandler(r *Route, headerName, headerValue string, target *Backend) fu
 		appendXForwardForHeader(req, ctx.RemoteAddr().String())
 
 		if len(ctx.Request.Header.Peek(headerName)) > 0 {
-			if err = r.HTTPDo(req, target, HTTPReturn(ctx, nil)); err != nil {
-				ctx.Error(handleNetError(err))
+			if err = r.HTTPDo(ctx, req, target, true, HTTPReturn(ctx, nil)); err != nil {
+				handleError(ctx, err)
 			}
 			return
 		}
@@ -206,48 +339,89 @@ func HeaderHandler(r *Route, headerName, headerValue string, target *Backend) fu
 		target, err = r.getNextBackend()
 		if err != nil {
 			log.Debugf("Could not get next backend: %v", err)
-			ctx.Error("No Upstream Host Available", 503)
+			middleware.Error(ctx, "No Upstream Host Available", 503)
 			return
 		}
-		if err = r.HTTPDo(req, target, HTTPReturn(ctx, nil)); err != nil {
-			ctx.Error(handleNetError(err))
+		if err = r.HTTPDo(ctx, req, target, false, HTTPReturn(ctx, nil)); err != nil {
+			handleError(ctx, err)
 		}
 	}
 }
 
 // ShadowHandler accepts requests of the downstream client and forward it to two backends
 // (the new version and the old version). Only the response of the old version is
-// returned. Both responses can then be compared
+// returned. Both responses can then be compared. The shadow is sent like a Mirror
+// of the route with 100 percent
 func ShadowHandler(r *Route, shadow *Backend) func(ctx *fasthttp.RequestCtx) {
+	mirror := &Mirror{
+		Backend:     shadow.Name,
+		Percentage:  100,
+		MaxInFlight: shadowMaxInFlight,
+		backend:     shadow,
+		limit:       &ConcurrencyLimit{MaxInFlight: shadowMaxInFlight},
+	}
+	mirrors := []*Mirror{mirror}
+
 	return func(ctx *fasthttp.RequestCtx) {
 		target, err := r.getNextBackend()
 		if err != nil {
 			log.Debugf("Could not get next backend: %v", err)
-			ctx.Error("No Upstream Host Available", 503)
+			middleware.Error(ctx, "No Upstream Host Available", 503)
 			return
 		}
+		r.startMirrors(ctx, mirrors)
+		forwardTo(r, ctx, target, false)
+	}
+}
 
-		req1 := fasthttp.AcquireRequest()
-		defer fasthttp.ReleaseRequest(req1)
-		ctx.Request.CopyTo(req1)
-		delRequestHopHeader(req1)
-		appendXForwardForHeader(req1, ctx.RemoteAddr().String())
-
-		req2 := fasthttp.AcquireRequest()
-		defer fasthttp.ReleaseRequest(req2)
-		req2.SetBody(req1.Body())
-		req1.Header.CopyTo(&req2.Header)
+// BlueGreenHandler forwards all requests to the active backend. If the routing
+// header is found, the request is forwarded to the preview backend
+func BlueGreenHandler(
+	r *Route, active, preview *Backend, headerName, headerValue string) func(ctx *fasthttp.RequestCtx) {
 
-		if err = r.HTTPDo(req1, target, HTTPReturn(ctx, nil)); err != nil {
-			ctx.Error(handleNetError(err))
+	return func(ctx *fasthttp.RequestCtx) {
+		target := active
+		if headerName != "" {
+			if value := ctx.Request.Header.Peek(headerName); len(value) > 0 &&
+				(headerValue == "" || string(value) == headerValue) {
+				target = preview
+			}
 		}
+		forwardTo(r, ctx, target, true)
+	}
+}
 
-		go func() {
-			if err = r.HTTPDo(req2, shadow, func(resp *fasthttp.Response) {
-				return
-			}); err != nil {
-				log.Infof("Shadow Request failed with %s", err.Error())
-			}
-		}()
+// forwardTo forwards the request to the given backend. If pinned is
+// set, retries are not forwarded to another backend
+func forwardTo(r *Route, ctx *fasthttp.RequestCtx, target *Backend, pinned bool) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx.Request.CopyTo(req)
+	delRequestHopHeader(req)
+	appendXForwardForHeader(req, ctx.RemoteAddr().String())
+
+	if err := r.HTTPDo(ctx, req, target, pinned, HTTPReturn(ctx, nil)); err != nil {
+		handleError(ctx, err)
+	}
+}
+
+// ABTestHandler assigns the user of the request to a variant and forwards
+// the request to the backend of the variant. The variant is sent in the variantHeader
+func ABTestHandler(
+	r *Route, variants []*Variant, bucketBy, bucketKey, variantHeader string) func(ctx *fasthttp.RequestCtx) {
+
+	return func(ctx *fasthttp.RequestCtx) {
+		variant := selectVariant(r.Name, bucketIdentifier(ctx, bucketBy, bucketKey), variants)
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		ctx.Request.CopyTo(req)
+		delRequestHopHeader(req)
+		appendXForwardForHeader(req, ctx.RemoteAddr().String())
+		req.Header.Set(variantHeader, variant.Name)
+
+		if err := r.HTTPDo(ctx, req, variant.backend, true, HTTPReturn(ctx, nil)); err != nil {
+			handleError(ctx, err)
+		}
 	}
 }
@@ -2,40 +2,98 @@ package route
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rgumi/depoy/conditional"
+	"github.com/rgumi/depoy/metrics"
+	"github.com/rgumi/depoy/util"
 	log "github.com/sirupsen/logrus"
 )
 
-var counter int
 var granularity = 10 * time.Second
 
+// SwitchoverStep is a step of a progressive delivery plan. When the step is
+// entered, the weight of Switchover.To is set to Weight. The step is held
+// at least for Duration and until MinRequests were sent to Switchover.To
+type SwitchoverStep struct {
+	Weight   uint8               `json:"weight" yaml:"weight"`
+	Duration util.ConfigDuration `json:"duration" yaml:"duration"`
+	// Conditions of the step. If empty, the conditions of the switchover are used
+	Conditions  []*conditional.Condition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	MinRequests int                      `json:"min_requests" yaml:"minRequests"`
+	// Pause waits until the switchover is resumed after the step is finished
+	Pause bool `json:"pause" yaml:"pause"`
+}
+
+// outcomes of a cycle
+const (
+	cyclePassed = iota
+	cycleFailed
+	cycleInconclusive
+)
+
+// Transition is a change of the status of a switchover
+type Transition struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason"`
+}
+
 // Switchover is used to configure a switch-over from
 // one backend to another. This can be used to gradually
 // increase the load to a backend by updating the
 // weights of the backends
 type Switchover struct {
-	ID                 int                      `json:"id"`
-	From               *Backend                 `json:"from"`
-	To                 *Backend                 `json:"to"`
-	Status             string                   `json:"status"`
-	Conditions         []*conditional.Condition `json:"conditions"`    // conditions that all need to be met to change
-	WeightChange       uint8                    `json:"weight_change"` // amount of change to the weights
-	Timeout            time.Duration            `json:"-"`             // duration to wait before changing weights
-	Route              *Route                   `json:"-"`             // route for which the switch is defined
-	Rollback           bool                     `json:"-"`             // If Switchover is cancled or aborted, should the weights of backends be reset?
-	AllowedFailures    int                      `json:"-"`             // amount of failures that are allowed before switchover is aborted
-	FailureCounter     int                      `json:"-"`
+	ID              uuid.UUID                `json:"id"`
+	From            *Backend                 `json:"from"`
+	To              *Backend                 `json:"to"`
+	Status          string                   `json:"status"`
+	Conditions      []*conditional.Condition `json:"conditions"`    // conditions that all need to be met to change
+	WeightChange    uint8                    `json:"weight_change"` // amount of change to the weights
+	Steps           []*SwitchoverStep        `json:"steps"`         // plan of the switchover. If empty, WeightChange is used
+	CurrentStep     int                      `json:"current_step"`  // index of the current step of the plan
+	Timeout         time.Duration            `json:"-"`             // duration to wait before changing weights
+	Route           *Route                   `json:"-"`             // route for which the switch is defined
+	Rollback        bool                     `json:"-"`             // If Switchover is cancled or aborted, should the weights of backends be reset?
+	AllowedFailures int                      `json:"-"`             // amount of failures that are allowed before switchover is aborted
+	FailureCounter  int                      `json:"-"`
+	Transitions     []Transition             `json:"transitions"` // changes of the status
+	BakePeriod      time.Duration            `json:"-"`           // duration after success in which alarms of To cause a rollback
+	BakeUntil       time.Time                `json:"bake_until"`  // end of the current bake period
+	StartAt         time.Time                `json:"start_at"`    // scheduled start of the switchover
+	// MinRequests is the amount of requests to To that are required to evaluate a cycle
+	MinRequests int `json:"min_requests"`
+	// MaxInconclusive is the amount of consecutive inconclusive cycles after which
+	// InconclusiveAction (fail or pause) is applied. If 0, the action is never applied
+	MaxInconclusive     int    `json:"max_inconclusive"`
+	InconclusiveAction  string `json:"inconclusive_action"`
+	InconclusiveCounter int    `json:"inconclusive_counter"`
+	// Release is the name of the release which manages the switchover
+	Release            string `json:"release,omitempty"`
 	toRollbackWeight   uint8
 	fromRollbackWeight uint8
+	stepStart          time.Time
+	stepRequests       int
+	awaitsPromotion    bool      // paused at a pause point of the plan
+	canary             *Strategy // set with the initial weights when the switchover begins (force)
+	started            bool      // initial weights were set
+	baking             bool      // successful and in bake period
+	pausedByWindow     bool      // paused outside of the deployment windows of the route
+	lastMetrics        map[string]float64
+	history            *SwitchoverHistory
 	killChan           chan int // chan to stop the switchover process
+	mux                sync.Mutex
 }
 
 func NewSwitchover(
 	from, to *Backend,
 	route *Route,
 	conditions []*conditional.Condition,
+	steps []*SwitchoverStep,
 	timeout time.Duration,
 	allowedFailures int,
 	weightChange uint8, rollback bool) (*Switchover, error) {
@@ -50,14 +108,27 @@ func NewSwitchover(
 	for _, cond := range conditions {
 		cond.Compile()
 	}
+	var lastWeight uint8
+	for i, step := range steps {
+		if step.Weight > 100 || step.Weight < lastWeight {
+			return nil, fmt.Errorf("Weight of step %d must be between %d and 100", i, lastWeight)
+		}
+		if len(step.Conditions) == 0 && len(conditions) == 0 {
+			return nil, fmt.Errorf("Step %d does not have any conditions", i)
+		}
+		for _, cond := range step.Conditions {
+			cond.Compile()
+		}
+		lastWeight = step.Weight
+	}
 
-	counter++
 	return &Switchover{
-		ID:              counter,
+		ID:              uuid.New(),
 		From:            from,
 		To:              to,
 		Status:          "Registered",
 		Conditions:      conditions,
+		Steps:           steps,
 		Timeout:         timeout,
 		WeightChange:    weightChange,
 		AllowedFailures: allowedFailures,
@@ -69,24 +140,249 @@ func NewSwitchover(
 
 // Stop the switchover process
 func (s *Switchover) Stop() {
-	if s.Status == "Running" {
-		s.Status = "Stopped"
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.Status == "Running" || s.Status == "Paused" || s.Status == "Scheduled" {
+		s.setStatus("Stopped", "Switchover was removed")
 	}
+	s.stop()
+}
+
+// stop the switchover process. The caller must hold the lock
+func (s *Switchover) stop() {
 	if s.Rollback && s.Status == "Failed" {
 		log.Warnf("Switchover from %v to %v failed", s.From.ID, s.To.ID)
-		s.From.UpdateWeight(s.fromRollbackWeight)
-		s.To.UpdateWeight(s.toRollbackWeight)
-		s.To.updateWeigth()
+		s.rollback()
+	}
+	if s.Status == "Success" && s.BakePeriod > 0 && s.BakeUntil.IsZero() {
+		s.startBake()
+	} else {
+		s.baking = false
+		s.finish()
+	}
+	select {
+	case s.killChan <- 1:
+	default:
+		// switchover was already stopped
+	}
+}
+
+// rollback resets the weights of the backends to the weights before the switchover
+func (s *Switchover) rollback() {
+	s.From.UpdateWeight(s.fromRollbackWeight)
+	s.To.UpdateWeight(s.toRollbackWeight)
+	s.To.updateWeigth()
+	s.record("WeightChanged", "Weights were reset", nil, nil)
+}
+
+// setStatus updates the status and records the transition. The caller must hold the lock
+func (s *Switchover) setStatus(status, reason string) {
+	s.Transitions = append(s.Transitions, Transition{
+		Timestamp: time.Now(),
+		From:      s.Status,
+		To:        status,
+		Reason:    reason,
+	})
+	s.Route.emitEvent("SwitchoverStatusChanged", "Switchover %v changed from %s to %s: %s",
+		s.ID, s.Status, status, reason)
+	s.Status = status
+	s.record("StatusChanged", reason, nil, nil)
+}
+
+// isActive returns if the switchover is running, paused or baking
+func (s *Switchover) isActive() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.Status == "Running" || s.Status == "Paused" || s.Status == "Scheduled" || s.baking
+}
+
+// startBake starts the bake period. From keeps its weight of 0 and stays
+// registered. The caller must hold the lock
+func (s *Switchover) startBake() {
+	s.baking = true
+	s.BakeUntil = time.Now().Add(s.BakePeriod)
+	s.record("BakeStarted", fmt.Sprintf("Baking until %s", s.BakeUntil.Format(time.RFC3339)), nil, nil)
+	time.AfterFunc(s.BakePeriod, s.endBake)
+}
+
+// endBake finishes the bake period without a rollback
+func (s *Switchover) endBake() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.baking {
+		return
+	}
+	s.baking = false
+	s.record("BakeFinished", "No alerts were alarming during the bake period", nil, nil)
+	s.finish()
+}
+
+// onAlarm resets the weights to the weights before the switchover
+// if an alert of To is alarming during the bake period
+func (s *Switchover) onAlarm(alert metrics.Alert) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.baking {
+		return
+	}
+	s.baking = false
+	s.rollback()
+	s.setStatus("RolledBack", fmt.Sprintf("Alert (%s) of %s was alarming during the bake period",
+		alert.Metric, s.To.Name))
+	s.Route.emitEvent("SwitchoverRolledBack", "Restored weights of %s (%d) and %s (%d)",
+		s.From.Name, s.fromRollbackWeight, s.To.Name, s.toRollbackWeight)
+	s.finish()
+}
+
+// Pause freezes the weights of the backends until the switchover is resumed
+func (s *Switchover) Pause(reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Release != "" {
+		return fmt.Errorf("Switchover is managed by release %s", s.Release)
+	}
+	if s.Status != "Running" {
+		return fmt.Errorf("Only a running switchover can be paused (Status: %s)", s.Status)
+	}
+	s.setStatus("Paused", reason)
+	return nil
+}
+
+// Resume continues a paused switchover. A switchover which waits at a
+// pause point of its plan can only be continued by Promote
+func (s *Switchover) Resume(reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Release != "" {
+		return fmt.Errorf("Switchover is managed by release %s", s.Release)
+	}
+	if s.Status != "Paused" {
+		return fmt.Errorf("Only a paused switchover can be resumed (Status: %s)", s.Status)
+	}
+	if s.awaitsPromotion {
+		return fmt.Errorf("Switchover waits for promotion at step %d", s.CurrentStep)
 	}
-	s.killChan <- 1
+	if blocked := s.blocked(time.Now()); blocked != "" {
+		return fmt.Errorf("Weights cannot be changed: %s", blocked)
+	}
+	s.setStatus("Running", reason)
+	s.resetConditions()
+	s.pausedByWindow = false
+	if !s.started {
+		s.begin()
+	}
+	return nil
+}
+
+// Promote continues a switchover which waits at a pause point of its plan with
+// the next step. Otherwise all traffic is forwarded to Switchover.To like Complete
+func (s *Switchover) Promote(reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Release != "" {
+		return fmt.Errorf("Switchover is managed by release %s", s.Release)
+	}
+	if s.Status != "Running" && s.Status != "Paused" {
+		return fmt.Errorf("Only an active switchover can be promoted (Status: %s)", s.Status)
+	}
+	if blocked := s.blocked(time.Now()); blocked != "" {
+		return fmt.Errorf("Weights cannot be changed: %s", blocked)
+	}
+	if !s.awaitsPromotion {
+		s.complete(reason)
+		return nil
+	}
+	s.awaitsPromotion = false
+	s.setStatus("Running", reason)
+	s.nextStep()
+	return nil
+}
+
+// Complete skips the remaining steps, forwards all traffic to
+// Switchover.To and finishes the switchover
+func (s *Switchover) Complete(reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Release != "" {
+		return fmt.Errorf("Switchover is managed by release %s", s.Release)
+	}
+	if s.Status != "Running" && s.Status != "Paused" {
+		return fmt.Errorf("Only an active switchover can be completed (Status: %s)", s.Status)
+	}
+	if blocked := s.blocked(time.Now()); blocked != "" {
+		return fmt.Errorf("Weights cannot be changed: %s", blocked)
+	}
+	s.complete(reason)
+	return nil
+}
+
+// complete forwards all traffic to Switchover.To. The caller must hold the lock
+func (s *Switchover) complete(reason string) {
+	s.awaitsPromotion = false
+	s.setWeights(100)
+	s.setStatus("Success", reason)
+	s.stop()
+}
+
+// Abort stops the switchover and resets the weights of the
+// backends to the weights before the switchover
+func (s *Switchover) Abort(reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Release != "" {
+		return fmt.Errorf("Switchover is managed by release %s", s.Release)
+	}
+	if s.Status != "Running" && s.Status != "Paused" {
+		return fmt.Errorf("Only an active switchover can be aborted (Status: %s)", s.Status)
+	}
+	s.rollback()
+	s.setStatus("Aborted", reason)
+	s.stop()
+	return nil
 }
 
 // Start the switchover process
 func (s *Switchover) Start() {
+	if wait := time.Until(s.StartAt); wait > 0 {
+		s.mux.Lock()
+		s.setStatus("Scheduled", fmt.Sprintf("Starting at %s", s.StartAt.Format(time.RFC3339)))
+		s.mux.Unlock()
+
+		select {
+		case _ = <-s.killChan:
+			log.Warnf("Killed scheduled SwitchOver %v of Route %v", s.ID, s.Route.Name)
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	s.mux.Lock()
+	now := time.Now()
+	if period := s.Route.frozen(now); period != nil {
+		s.setStatus("Aborted", fmt.Sprintf("Switchovers are frozen until %s (%s)",
+			period.End.Format(time.RFC3339), period.Reason))
+		s.stop()
+		s.mux.Unlock()
+		return
+	}
 	s.toRollbackWeight = s.To.Weigth
 	s.fromRollbackWeight = s.From.Weigth
-	s.Status = "Running"
-outer:
+	s.setStatus("Running", "Switchover was started")
+	if blocked := s.blocked(now); blocked != "" {
+		s.pausedByWindow = true
+		s.setStatus("Paused", blocked)
+	} else {
+		s.begin()
+	}
+	s.mux.Unlock()
+
 	for {
 		select {
 		case _ = <-s.killChan:
@@ -94,76 +390,282 @@ outer:
 			return
 
 		case now := <-time.After(s.Timeout):
+			s.cycle(now)
+		}
+	}
+}
 
-			metrics, err := s.Route.MetricsRepo.ReadRatesOfBackend(
-				s.To.ID, now.Add(-s.Timeout), now)
-			if err != nil {
-				log.Trace(err)
-				continue
-			}
-			// begin cycle => check each condition if true
-			for _, condition := range s.Conditions {
-				if condition.IsTrue(metrics) && s.To.Active {
-					if condition.TriggerTime.IsZero() {
-						// evaluated later by adding activeFor-Duration
-						condition.TriggerTime = now
-					} else {
-						// check if condition was active for long enough
-						if condition.TriggerTime.Add(condition.GetActiveFor()).Before(now) {
-							log.Debugf("Updating status of condition %v %v %v to true",
-								condition.Metric, condition.Operator, condition.Threshold,
-							)
-							condition.Status = true
-
-						}
-					}
-
-					// condition is not true or backend is not active
-				} else {
-					condition.TriggerTime = time.Time{}
-					condition.Status = false
-				}
-			}
+// cycle evaluates the conditions of the switchover and updates
+// the weights of the backends if all conditions are met
+func (s *Switchover) cycle(now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
-			// end of cycle, check conditions
-			for _, condition := range s.Conditions {
-				// to avoid a failureCounter increment when the trigger is true but the activeFor-duration
-				// is not, check if the triggertime is set
-				if !condition.Status && condition.TriggerTime.IsZero() {
-					// if any condition is not true, cycle is failed
-					log.Debugf("Condition (%s) of Switchover %v (%s) is false",
-						condition.Metric, s.ID, s.Route.Name,
+	// weights are only changed within the deployment windows and outside of the freeze periods
+	blocked := s.blocked(now)
+	if s.Status == "Paused" && s.pausedByWindow && blocked == "" {
+		s.pausedByWindow = false
+		s.setStatus("Running", "Weights can be changed again")
+		s.resetConditions()
+		if !s.started {
+			s.begin()
+			return
+		}
+	}
+	if s.Status != "Running" {
+		return
+	}
+	if blocked != "" {
+		s.pausedByWindow = true
+		s.setStatus("Paused", blocked)
+		return
+	}
+	outcome, condition, message, metrics := s.evaluate(now)
+	switch outcome {
+	case cycleInconclusive:
+		s.inconclusive(message, condition, metrics)
+		return
+
+	case cycleFailed:
+		s.FailureCounter++
+		s.InconclusiveCounter = 0
+		s.record("CycleFailed", fmt.Sprintf("Cycle %d failed", s.FailureCounter), condition, metrics)
+		// check if allowed failures have been reached - if configured
+		if s.AllowedFailures > 0 && s.FailureCounter > s.AllowedFailures {
+			// failed too often...
+			s.setStatus("Failed", fmt.Sprintf(
+				"Condition (%s) was false in %d cycles", condition.Metric, s.FailureCounter))
+			s.stop()
+		}
+		return
+	}
+	s.InconclusiveCounter = 0
+
+	if len(s.Steps) == 0 {
+		// if all conditions are true, increase the weight of the new route
+		s.shiftWeights(s.WeightChange, "All conditions were met", metrics)
+		if s.done() {
+			s.succeed()
+		}
+		return
+	}
+
+	// plan of steps: the step is finished if it was held for long enough
+	// and enough requests were sent to the new backend
+	s.stepRequests += clientRequests(metrics)
+	step := s.Steps[s.CurrentStep]
+	if now.Sub(s.stepStart) < step.Duration.Duration || s.stepRequests < step.MinRequests {
+		return
+	}
+	if step.Pause {
+		s.awaitsPromotion = true
+		s.setStatus("Paused", fmt.Sprintf("Step %d was finished", s.CurrentStep))
+		return
+	}
+	s.nextStep()
+}
+
+// clientRequests returns the amount of requests of the clients without the health checks
+func clientRequests(metrics map[string]float64) int {
+	return int(metrics["TotalResponses"] - metrics["HealthChecks"])
+}
+
+// evaluate reads the metrics of To and evaluates the conditions of the current
+// cycle. It returns the outcome, the condition which caused it and a message.
+// The caller must hold the lock
+func (s *Switchover) evaluate(now time.Time) (
+	int, *conditional.Condition, string, map[string]float64) {
+
+	metrics, err := s.Route.MetricsRepo.ReadRatesOfBackend(
+		s.To.ID, now.Add(-s.Timeout), now)
+	if err != nil {
+		// no metrics were recorded in the window, so no requests were sent to To
+		log.Trace(err)
+		s.lastMetrics = metrics
+		return cycleInconclusive, nil, fmt.Sprintf("0 requests were sent to %s (required: %d)",
+			s.To.Name, s.MinRequests), metrics
+	}
+	s.lastMetrics = metrics
+	conditions := s.currentConditions()
+
+	// a cycle without enough requests is neither a success nor a failure
+	requests := clientRequests(metrics)
+	if s.To.Active && (requests == 0 || requests < s.MinRequests) {
+		return cycleInconclusive, nil, fmt.Sprintf("%d requests were sent to %s (required: %d)",
+			requests, s.To.Name, s.MinRequests), metrics
+	}
+	var inconclusive *conditional.Condition
+
+	// begin cycle => check each condition if true
+	for _, condition := range conditions {
+		if s.To.Active && requests < condition.MinRequests {
+			// not enough data to evaluate the condition
+			inconclusive = condition
+			continue
+		}
+		if condition.IsTrue(metrics) && s.To.Active {
+			if condition.TriggerTime.IsZero() {
+				// evaluated later by adding activeFor-Duration
+				condition.TriggerTime = now
+			} else {
+				// check if condition was active for long enough
+				if condition.TriggerTime.Add(condition.GetActiveFor()).Before(now) {
+					log.Debugf("Updating status of condition %v %v %v to true",
+						condition.Metric, condition.Operator, condition.Threshold,
 					)
-					s.FailureCounter++
-					// check if allowed failures have been reached - if configured
-					if s.AllowedFailures > 0 && s.FailureCounter > s.AllowedFailures {
-						// failed too often...
-						s.Status = "Failed"
-						s.Stop()
-					}
-					// continue cycle
-					continue outer
+					condition.Status = true
+
 				}
 			}
-			// if all conditions are true, increase the weight of the new route
-			s.From.UpdateWeight(s.From.Weigth - s.WeightChange)
-			s.To.UpdateWeight(s.To.Weigth + s.WeightChange)
-			// As both routes are part of the same route, both will be updated
-			s.To.updateWeigth()
-			log.Infof("Switchover %d - Updating weights of Backends by %d", s.ID, s.WeightChange)
-			// reset the conditions
-			for _, condition := range s.Conditions {
-				condition.TriggerTime = time.Time{}
-				condition.Status = false
-			}
-			if s.From.Weigth <= 0 || s.To.Weigth >= 100 {
-				// switchover was successful, all traffic is forwarded to new backend
-				log.Infof("Switchover %d -  %s from %v to %v was successful",
-					s.ID, s.Route.Name, s.From.ID, s.To.ID,
-				)
-				s.Status = "Success"
-				s.Stop()
-			}
+
+			// condition is not true or backend is not active
+		} else {
+			condition.TriggerTime = time.Time{}
+			condition.Status = false
+		}
+	}
+
+	// end of cycle, check conditions
+	for _, condition := range conditions {
+		if s.To.Active && requests < condition.MinRequests {
+			continue
 		}
+		// to avoid a failureCounter increment when the trigger is true but the activeFor-duration
+		// is not, check if the triggertime is set
+		if !condition.Status && condition.TriggerTime.IsZero() {
+			// if any condition is not true, cycle is failed
+			log.Debugf("Condition (%s) of Switchover %v (%s) is false",
+				condition.Metric, s.ID, s.Route.Name,
+			)
+			return cycleFailed, condition, "", metrics
+		}
+	}
+	if inconclusive != nil {
+		return cycleInconclusive, inconclusive, fmt.Sprintf("%d requests were sent to %s (required: %d)",
+			requests, s.To.Name, inconclusive.MinRequests), metrics
 	}
+	return cyclePassed, nil, "", metrics
+}
+
+// shiftWeights moves weightChange from From to To. The caller must hold the lock
+func (s *Switchover) shiftWeights(weightChange uint8, message string, metrics map[string]float64) {
+	s.From.UpdateWeight(s.From.Weigth - weightChange)
+	s.To.UpdateWeight(s.To.Weigth + weightChange)
+	// As both routes are part of the same route, both will be updated
+	s.To.updateWeigth()
+	log.Infof("Switchover %v - Updating weights of Backends by %d", s.ID, weightChange)
+	s.record("WeightChanged", message, nil, metrics)
+	s.resetConditions()
+}
+
+// done returns if all traffic is forwarded to To. The caller must hold the lock
+func (s *Switchover) done() bool {
+	return s.From.Weigth <= 0 || s.To.Weigth >= 100
+}
+
+// inconclusive records a cycle without enough data. If too many consecutive cycles
+// were inconclusive, the switchover is failed or paused. The caller must hold the lock
+func (s *Switchover) inconclusive(
+	message string, condition *conditional.Condition, metrics map[string]float64) {
+
+	s.InconclusiveCounter++
+	log.Debugf("Cycle of Switchover %v (%s) is inconclusive: %s", s.ID, s.Route.Name, message)
+	s.record("CycleInconclusive", message, condition, metrics)
+
+	if s.MaxInconclusive <= 0 || s.InconclusiveCounter <= s.MaxInconclusive {
+		return
+	}
+	reason := fmt.Sprintf("%d consecutive cycles were inconclusive", s.InconclusiveCounter)
+	s.InconclusiveCounter = 0
+	if strings.ToLower(s.InconclusiveAction) == "fail" {
+		s.setStatus("Failed", reason)
+		s.stop()
+		return
+	}
+	s.setStatus("Paused", reason)
+}
+
+// currentConditions returns the conditions that need to be met in the current cycle
+func (s *Switchover) currentConditions() []*conditional.Condition {
+	if len(s.Steps) > 0 && len(s.Steps[s.CurrentStep].Conditions) > 0 {
+		return s.Steps[s.CurrentStep].Conditions
+	}
+	return s.Conditions
+}
+
+func (s *Switchover) resetConditions() {
+	for _, condition := range s.currentConditions() {
+		condition.TriggerTime = time.Time{}
+		condition.Status = false
+	}
+}
+
+// blocked returns why the weights cannot be changed at t. If they
+// can be changed, an empty string is returned
+func (s *Switchover) blocked(t time.Time) string {
+	if period := s.Route.frozen(t); period != nil {
+		return fmt.Sprintf("Switchovers are frozen until %s (%s)",
+			period.End.Format(time.RFC3339), period.Reason)
+	}
+	if !s.Route.inDeploymentWindow(t) {
+		return "Outside of the deployment windows of the route"
+	}
+	return ""
+}
+
+// begin sets the initial strategy and weights of the switchover. The caller must hold the lock
+func (s *Switchover) begin() {
+	s.started = true
+	if s.canary != nil {
+		s.Route.SetStrategy(s.canary)
+		s.From.UpdateWeight(100 - s.WeightChange)
+		s.To.UpdateWeight(s.WeightChange)
+		s.To.updateWeigth()
+		s.record("WeightChanged", fmt.Sprintf("Weight of %s was set to %d", s.To.Name, s.WeightChange), nil, nil)
+	}
+	if len(s.Steps) > 0 {
+		s.enterStep(0)
+	}
+}
+
+// enterStep sets the weights of the backends to the weights of the given step
+func (s *Switchover) enterStep(i int) {
+	s.resetConditions()
+	s.CurrentStep = i
+	s.stepStart = time.Now()
+	s.stepRequests = 0
+	s.setWeights(s.Steps[i].Weight)
+	log.Infof("Switchover %v - Entered step %d with weight %d", s.ID, i, s.Steps[i].Weight)
+}
+
+// nextStep enters the next step of the plan or finishes the switchover
+// if the last step was finished
+func (s *Switchover) nextStep() {
+	if s.CurrentStep+1 < len(s.Steps) {
+		s.enterStep(s.CurrentStep + 1)
+		return
+	}
+	s.setWeights(100)
+	s.succeed()
+}
+
+// setWeights sets the weight of To and the remaining weight to From
+func (s *Switchover) setWeights(toWeight uint8) {
+	if toWeight > 100 {
+		toWeight = 100
+	}
+	s.From.UpdateWeight(100 - toWeight)
+	s.To.UpdateWeight(toWeight)
+	// As both routes are part of the same route, both will be updated
+	s.To.updateWeigth()
+	s.record("WeightChanged", fmt.Sprintf("Weight of %s was set to %d", s.To.Name, toWeight), nil, s.lastMetrics)
+}
+
+func (s *Switchover) succeed() {
+	// switchover was successful, all traffic is forwarded to new backend
+	log.Infof("Switchover %v -  %s from %v to %v was successful",
+		s.ID, s.Route.Name, s.From.ID, s.To.ID,
+	)
+	s.setStatus("Success", "All traffic is forwarded to Switchover.To")
+	s.stop()
 }
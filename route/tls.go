@@ -0,0 +1,89 @@
+package route
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/rgumi/depoy/util"
+)
+
+// TLSPolicy defines the tls settings of the connections to the backends. The
+// files are reloaded from disk when they change. If CAFile is empty, the
+// certificates of the backends are verified with the system roots
+type TLSPolicy struct {
+	// CAFile is a PEM bundle of the CAs which are trusted
+	CAFile string `json:"ca_file,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate which is presented for mutual tls
+	CertFile string `json:"cert_file,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"key_file,omitempty" yaml:"keyFile,omitempty"`
+	// ServerName overrides the SNI and the name which is verified
+	ServerName string `json:"server_name,omitempty" yaml:"serverName,omitempty"`
+	// MinVersion is the minimal tls version (1.0, 1.1, 1.2 or 1.3)
+	MinVersion string `json:"min_version" yaml:"minVersion" default:"1.2"`
+	// CipherSuites restricts the cipher suites of tls 1.0 - 1.2 (e. g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	CipherSuites       []string `json:"cipher_suites,omitempty" yaml:"cipherSuites,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify" yaml:"insecureSkipVerify"`
+
+	config *tls.Config
+}
+
+// Validate checks the configuration, loads the files and prepares the tls config
+func (p *TLSPolicy) Validate() error {
+	minVersion, err := util.TLSVersion(p.MinVersion)
+	if err != nil {
+		return err
+	}
+	cipherSuites, err := util.CipherSuites(p.CipherSuites)
+	if err != nil {
+		return err
+	}
+	certs, err := util.NewCertReloader(p.CertFile, p.KeyFile, p.CAFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		ServerName:         p.ServerName,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+	if p.CertFile != "" {
+		config.GetClientCertificate = certs.GetClientCertificate
+	}
+	if p.CAFile != "" && !p.InsecureSkipVerify {
+		// the default verification would use the CAs which were loaded on creation
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return certs.VerifyPeer(cs.PeerCertificates, cs.ServerName, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	p.config = config
+	return nil
+}
+
+// SetTLSPolicy validates and sets the TLSPolicy of the route. It is used for all
+// backends without their own policy. If policy is nil, the default settings are used
+func (r *Route) SetTLSPolicy(policy *TLSPolicy) error {
+	var config *tls.Config
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		config = policy.config
+	}
+	r.Client.SetTLSConfig(config)
+	r.mux.Lock()
+	r.TLSPolicy = policy
+	r.mux.Unlock()
+	return nil
+}
+
+// tlsConfig returns the tls config of the backend. If the backend
+// has no TLSPolicy, nil is returned and the config of the route is used
+func (b *Backend) tlsConfig() *tls.Config {
+	if b.TLSPolicy == nil || b.Addr.Scheme != "https" {
+		return nil
+	}
+	return b.TLSPolicy.config
+}
@@ -0,0 +1,72 @@
+package route
+
+import (
+	"fmt"
+
+	"github.com/rgumi/depoy/middleware"
+	"github.com/rgumi/depoy/tracing"
+	"github.com/valyala/fasthttp"
+)
+
+// SetTraceSampleRate sets the ratio of the traces of the route which are sampled.
+// If rate is nil, the global sample rate is used
+func (r *Route) SetTraceSampleRate(rate *float64) error {
+	if rate != nil && (*rate < 0 || *rate > 1) {
+		return fmt.Errorf("TraceSampleRate must be between 0 and 1")
+	}
+	r.mux.Lock()
+	r.TraceSampleRate = rate
+	r.mux.Unlock()
+	return nil
+}
+
+// startSpan adds the attributes of the route to the server span of the request
+// and makes the sampling decision if the downstream did not already make it
+func (r *Route) startSpan(ctx *fasthttp.RequestCtx, strategy *Strategy, sampleRate *float64) {
+	span := tracing.FromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.SetName(string(ctx.Method()) + " " + r.Prefix)
+	span.SetAttribute("depoy.route", r.Name)
+	span.SetAttribute("depoy.strategy", strategy.Type)
+	span.SetAttribute("depoy.request_id", middleware.GetRequestID(ctx))
+	if sampleRate != nil {
+		span.Sample(*sampleRate)
+	}
+}
+
+// startClientSpan starts the span of an attempt to send the request
+// to the target and propagates it to the target using the traceparent header
+func (r *Route) startClientSpan(
+	ctx *fasthttp.RequestCtx, req *fasthttp.Request,
+	target *Backend, attempt int) *tracing.Span {
+
+	span := tracing.StartClientSpan(tracing.FromContext(ctx), string(req.Header.Method()))
+	if span == nil {
+		return nil
+	}
+	span.SetAttribute("depoy.route", r.Name)
+	span.SetAttribute("depoy.backend.id", target.ID.String())
+	span.SetAttribute("depoy.backend.name", target.Name)
+	span.SetAttribute("depoy.attempt", attempt)
+	span.SetAttribute("http.method", string(req.Header.Method()))
+	span.SetAttribute("http.url", req.URI().String())
+	span.Inject(req)
+	return span
+}
+
+func finishClientSpan(span *tracing.Span, resp *fasthttp.Response, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.SetError(err.Error())
+	} else {
+		span.SetAttribute("http.status_code", resp.StatusCode())
+		if resp.StatusCode() >= 500 {
+			span.SetError(fasthttp.StatusMessage(resp.StatusCode()))
+		}
+	}
+	span.Finish()
+}
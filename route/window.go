@@ -0,0 +1,151 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// DeploymentWindow is a recurring timeframe in which the weights of a switchover
+// may be increased. If End is before Start, the window ends on the following day
+type DeploymentWindow struct {
+	// Days on which the window starts (e. g. Mon, Tue). If empty, the window starts every day
+	Days     []string `json:"days" yaml:"days"`
+	Start    string   `json:"start" yaml:"start" default:"00:00"`
+	End      string   `json:"end" yaml:"end" default:"23:59"`
+	Timezone string   `json:"timezone" yaml:"timezone" default:"UTC"`
+
+	days     map[time.Weekday]bool
+	start    int // minutes of the day
+	end      int
+	location *time.Location
+}
+
+// Validate checks the configuration and prepares the DeploymentWindow for usage
+func (w *DeploymentWindow) Validate() (err error) {
+	if w.location, err = time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("Invalid timezone of deployment window (%s)", w.Timezone)
+	}
+	if w.start, err = minuteOfDay(w.Start); err != nil {
+		return err
+	}
+	if w.end, err = minuteOfDay(w.End); err != nil {
+		return err
+	}
+	w.days = make(map[time.Weekday]bool, len(w.Days))
+	for _, day := range w.Days {
+		name := strings.ToLower(day)
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		weekday, found := weekdays[name]
+		if !found {
+			return fmt.Errorf("Invalid day of deployment window (%s)", day)
+		}
+		w.days[weekday] = true
+	}
+	return nil
+}
+
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of deployment window (%s). Expected format 15:04", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *DeploymentWindow) startsOn(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// Contains returns if t is within the window
+func (w *DeploymentWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.startsOn(t.Weekday()) && minute >= w.start && minute < w.end
+	}
+	// window ends on the following day
+	return (w.startsOn(t.Weekday()) && minute >= w.start) ||
+		(w.startsOn(t.AddDate(0, 0, -1).Weekday()) && minute < w.end)
+}
+
+// FreezePeriod is a timeframe in which no switchovers can be started
+// and the weights of running switchovers are not changed
+type FreezePeriod struct {
+	Start  time.Time `json:"start" yaml:"start"`
+	End    time.Time `json:"end" yaml:"end"`
+	Reason string    `json:"reason" yaml:"reason"`
+}
+
+// Contains returns if t is within the freeze period
+func (f *FreezePeriod) Contains(t time.Time) bool {
+	return !t.Before(f.Start) && t.Before(f.End)
+}
+
+// SetDeploymentWindows validates and sets the deployment windows of the route.
+// If no window is set, switchovers can increase the weights at any time
+func (r *Route) SetDeploymentWindows(windows []*DeploymentWindow) error {
+	for _, window := range windows {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.DeploymentWindows = windows
+	return nil
+}
+
+// SetFreezePeriods validates and sets the freeze periods of the route
+func (r *Route) SetFreezePeriods(periods []*FreezePeriod) error {
+	for _, period := range periods {
+		if !period.End.After(period.Start) {
+			return fmt.Errorf("End of freeze period must be after its start")
+		}
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.FreezePeriods = periods
+	return nil
+}
+
+// inDeploymentWindow returns if t is within any deployment window of the route
+func (r *Route) inDeploymentWindow(t time.Time) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if len(r.DeploymentWindows) == 0 {
+		return true
+	}
+	for _, window := range r.DeploymentWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// frozen returns the freeze period of the route which contains t
+func (r *Route) frozen(t time.Time) *FreezePeriod {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, period := range r.FreezePeriods {
+		if period.Contains(t) {
+			return period
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package router
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/valyala/fasthttp"
@@ -10,6 +12,24 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// MatchPrefix matches all paths which start with the pattern
+	MatchPrefix = "prefix"
+	// MatchSegment matches all paths which start with the pattern at a segment
+	// boundary. /api matches /api and /api/users but not /apifoo
+	MatchSegment = "segment"
+	// MatchExact only matches the pattern itself
+	MatchExact = "exact"
+	// MatchTemplate matches paths with the same segments as the pattern. Segments
+	// in braces (e. g. /users/{id}/orders) match any value and are captured as parameters
+	MatchTemplate = "template"
+	// MatchRegex matches paths which are fully matched by the regular expression.
+	// Named and numbered groups are captured as parameters
+	MatchRegex = "regex"
+
+	paramsKey = "router.params"
+)
+
 func defaultErrorHandler(ctx *fasthttp.RequestCtx, e error) {
 	ctx.Response.SetStatusCode(500)
 	ctx.Response.SetBody([]byte(e.Error()))
@@ -19,8 +39,23 @@ func defaultNotFoundHandler(ctx *fasthttp.RequestCtx) {
 	ctx.Response.SetStatusCode(404)
 }
 
+type handle struct {
+	match    string
+	pattern  string
+	handler  fasthttp.RequestHandler
+	segments []string
+	literals int
+	regex    *regexp.Regexp
+}
+
+// Router resolves the handler of a request by its method and path. If multiple
+// handles match, the first of the following is used: exact, template (most
+// literal segments first), regex (longest pattern first) and longest prefix or segment
 type Router struct {
 	tree            map[string]*radix.Tree
+	exact           map[string]map[string]*handle
+	templates       map[string][]*handle
+	regexes         map[string][]*handle
 	ErrorHandler    func(ctx *fasthttp.RequestCtx, e error)
 	NotFoundHandler func(ctx *fasthttp.RequestCtx)
 }
@@ -28,74 +63,309 @@ type Router struct {
 func NewRouter() *Router {
 	return &Router{
 		tree:            make(map[string]*radix.Tree),
+		exact:           make(map[string]map[string]*handle),
+		templates:       make(map[string][]*handle),
+		regexes:         make(map[string][]*handle),
 		ErrorHandler:    defaultErrorHandler,
 		NotFoundHandler: defaultNotFoundHandler,
 	}
 }
 
-func (r *Router) CheckIfHandleExists(method, prefix string) (bool, error) {
-	var err error
+// ValidatePattern checks if the pattern can be used with the match type
+func ValidatePattern(match, pattern string) error {
+	_, err := newHandle(match, pattern, nil)
+	return err
+}
 
-	// method cannot be empty
-	if method == "" {
-		err = fmt.Errorf("Method cannot be empty")
+func newHandle(match, pattern string, handler fasthttp.RequestHandler) (*handle, error) {
+	h := &handle{
+		match:   strings.ToLower(match),
+		pattern: pattern,
+		handler: handler,
 	}
-	// Prefix needs to be not empty and start with a /
-	if prefix == "" || string(prefix[0]) != "/" {
-		err = fmt.Errorf("Prefix cannot be empty and must start with a \"/\"")
+	if h.match == "" {
+		h.match = MatchPrefix
 	}
-	if err != nil {
-		return false, err
+	if h.match != MatchRegex && (pattern == "" || pattern[0] != '/') {
+		return nil, fmt.Errorf("Prefix cannot be empty and must start with a \"/\"")
 	}
 
-	// if no tree exists with given method, initialize it
-	if r.tree[method] == nil {
-		r.tree[method] = radix.New()
+	switch h.match {
+	case MatchPrefix, MatchSegment, MatchExact:
+
+	case MatchTemplate:
+		h.segments = strings.Split(pattern, "/")
+		names := make(map[string]bool)
+		for _, segment := range h.segments {
+			name, isParam := paramName(segment)
+			if !isParam {
+				if strings.ContainsAny(segment, "{}") {
+					return nil, fmt.Errorf("Invalid segment of template (%s)", segment)
+				}
+				h.literals++
+				continue
+			}
+			if name == "" || names[name] {
+				return nil, fmt.Errorf("Parameters of template must be named and unique (%s)", pattern)
+			}
+			names[name] = true
+		}
+
+	case MatchRegex:
+		regex, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil || pattern == "" {
+			return nil, fmt.Errorf("Invalid regular expression (%s)", pattern)
+		}
+		h.regex = regex
+
+	default:
+		return nil, fmt.Errorf("Unsupported match type (%s)", match)
+	}
+	return h, nil
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) < 2 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
+
+// matchPath returns if the path is matched by the template or regex
+// of the handle and the captured parameters
+func (h *handle) matchPath(path string) (bool, map[string]string) {
+	if h.match == MatchRegex {
+		values := h.regex.FindStringSubmatch(path)
+		if values == nil {
+			return false, nil
+		}
+		params := make(map[string]string, len(values)-1)
+		for i, name := range h.regex.SubexpNames() {
+			if i == 0 {
+				continue
+			}
+			params[fmt.Sprint(i)] = values[i]
+			if name != "" {
+				params[name] = values[i]
+			}
+		}
+		return true, params
+	}
 
-		// if no tree existed, no handle can exist for it
+	segments := strings.Split(path, "/")
+	if len(segments) != len(h.segments) {
 		return false, nil
 	}
+	params := make(map[string]string, len(h.segments)-h.literals)
+	for i, segment := range h.segments {
+		if name, isParam := paramName(segment); isParam {
+			if segments[i] == "" {
+				return false, nil
+			}
+			params[name] = segments[i]
+		} else if segment != segments[i] {
+			return false, nil
+		}
+	}
+	return true, params
+}
 
-	if _, exists := r.tree[method].Get(prefix); exists {
-		// handle already exists with this method
-		return true, fmt.Errorf("Handle already exists for method %s and prefix %s", method, prefix)
+// CheckIfHandleExists checks if a handle of any match type exists for the method and
+// pattern. If it exists, an error is returned. Use CheckIfHandleExistsMatch to
+// check a single match type
+func (r *Router) CheckIfHandleExists(method, pattern string) (bool, error) {
+	httpMethod := strings.ToUpper(method)
+	if httpMethod == "" {
+		return false, fmt.Errorf("Method cannot be empty")
+	}
+	for _, match := range []string{MatchPrefix, MatchExact, MatchTemplate, MatchRegex} {
+		if r.indexOf(httpMethod, match, pattern) >= 0 {
+			return true, fmt.Errorf("Handle already exists for method %s and pattern %s", httpMethod, pattern)
+		}
 	}
-	// Handle does not exist
 	return false, nil
 }
 
+// CheckIfHandleExistsMatch checks if a handle of the match type exists for the method
+// and pattern. Prefix and segment handles share their patterns. If it exists, an error is returned
+func (r *Router) CheckIfHandleExistsMatch(method, match, pattern string) (bool, error) {
+	httpMethod := strings.ToUpper(method)
+	// method cannot be empty
+	if httpMethod == "" {
+		return false, fmt.Errorf("Method cannot be empty")
+	}
+	if _, err := newHandle(match, pattern, nil); err != nil {
+		return false, err
+	}
+	if r.indexOf(httpMethod, match, pattern) < 0 {
+		// Handle does not exist
+		return false, nil
+	}
+	return true, fmt.Errorf("Handle already exists for method %s and pattern %s", httpMethod, pattern)
+}
+
+// indexOf returns the index of the handle of the pattern in the templates or regexes.
+// For the other match types 0 is returned if the handle exists. If it does not exist, -1 is returned
+func (r *Router) indexOf(method, match, pattern string) int {
+	switch strings.ToLower(match) {
+	case MatchExact:
+		if _, exists := r.exact[method][pattern]; exists {
+			return 0
+		}
+	case MatchTemplate, MatchRegex:
+		handles := r.templates[method]
+		if strings.ToLower(match) == MatchRegex {
+			handles = r.regexes[method]
+		}
+		for i, existing := range handles {
+			if existing.pattern == pattern {
+				return i
+			}
+		}
+	default:
+		if tree := r.tree[method]; tree != nil {
+			if _, exists := tree.Get(pattern); exists {
+				return 0
+			}
+		}
+	}
+	return -1
+}
+
+// Handle adds a handler for all paths which start with the prefix
 func (r *Router) Handle(method, prefix string, handler fasthttp.RequestHandler) error {
-	var err error
+	return r.HandleMatch(method, MatchPrefix, prefix, handler)
+}
+
+// HandleMatch adds a handler for all paths which are matched by
+// the pattern using the match type (e. g. MatchExact)
+func (r *Router) HandleMatch(method, match, pattern string, handler fasthttp.RequestHandler) error {
 	httpMethod := strings.ToUpper(method)
-	// check if the prefix & method combination already exists
-	_, err = r.CheckIfHandleExists(httpMethod, prefix)
+	if httpMethod == "" {
+		return fmt.Errorf("Method cannot be empty")
+	}
+	h, err := newHandle(match, pattern, handler)
 	if err != nil {
 		return err
 	}
-	log.Debugf("Adding new Handle {Method:%s Prefix: %s} to Router", httpMethod, prefix)
-	if _, updated := r.tree[httpMethod].Insert(prefix, handler); updated {
-		return fmt.Errorf("Updated an entry")
+	log.Debugf("Adding new Handle {Method:%s Match: %s Pattern: %s} to Router", httpMethod, h.match, pattern)
+
+	if _, err = r.CheckIfHandleExistsMatch(httpMethod, h.match, pattern); err != nil {
+		return err
+	}
+	switch h.match {
+	case MatchExact:
+		if r.exact[httpMethod] == nil {
+			r.exact[httpMethod] = make(map[string]*handle)
+		}
+		r.exact[httpMethod][pattern] = h
+
+	case MatchTemplate, MatchRegex:
+		handles := r.templates
+		if h.match == MatchRegex {
+			handles = r.regexes
+		}
+		handles[httpMethod] = append(handles[httpMethod], h)
+		sortHandles(handles[httpMethod])
+
+	default:
+		if r.tree[httpMethod] == nil {
+			r.tree[httpMethod] = radix.New()
+		}
+		if _, updated := r.tree[httpMethod].Insert(pattern, h); updated {
+			return fmt.Errorf("Updated an entry")
+		}
 	}
 	return nil
 }
 
-func (r *Router) RemoveHandle(method, prefix string) error {
-	var err error
-	httpMethod := strings.ToUpper(method)
-	// check if the prefix & method combination already exists
-	_, err = r.CheckIfHandleExists(httpMethod, prefix)
-	if err == nil {
+// sortHandles orders templates and regexes by their precedence. The order
+// does not depend on the order in which the handles were added
+func sortHandles(handles []*handle) {
+	sort.SliceStable(handles, func(i, j int) bool {
+		a, b := handles[i], handles[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		if len(a.pattern) != len(b.pattern) {
+			return len(a.pattern) > len(b.pattern)
+		}
+		return a.pattern < b.pattern
+	})
+}
+
+// RemoveHandle removes the handles of all match types of the method and pattern.
+// Use RemoveHandleMatch to remove the handle of a single match type
+func (r *Router) RemoveHandle(method, pattern string) error {
+	removed := false
+	for _, match := range []string{MatchPrefix, MatchExact, MatchTemplate, MatchRegex} {
+		if err := r.RemoveHandleMatch(method, match, pattern); err == nil {
+			removed = true
+		}
+	}
+	if !removed {
 		return fmt.Errorf("Handle does not exist")
 	}
+	return nil
+}
 
-	if _, deleted := r.tree[httpMethod].Delete(prefix); !deleted {
-		return fmt.Errorf("Could not delete handle")
+// RemoveHandleMatch removes the handle of the match type of the method and pattern
+func (r *Router) RemoveHandleMatch(method, match, pattern string) error {
+	httpMethod := strings.ToUpper(method)
+	i := r.indexOf(httpMethod, match, pattern)
+	if i < 0 {
+		return fmt.Errorf("Handle does not exist")
 	}
 
+	switch strings.ToLower(match) {
+	case MatchExact:
+		delete(r.exact[httpMethod], pattern)
+	case MatchTemplate:
+		r.templates[httpMethod] = append(r.templates[httpMethod][:i], r.templates[httpMethod][i+1:]...)
+	case MatchRegex:
+		r.regexes[httpMethod] = append(r.regexes[httpMethod][:i], r.regexes[httpMethod][i+1:]...)
+	default:
+		if _, deleted := r.tree[httpMethod].Delete(pattern); !deleted {
+			return fmt.Errorf("Could not delete handle")
+		}
+	}
 	// delete successful
 	return nil
 }
 
+// lookup returns the handle with the highest precedence which matches the path
+func (r *Router) lookup(method, path string) (*handle, map[string]string) {
+	if h, found := r.exact[method][path]; found {
+		return h, nil
+	}
+	for _, h := range r.templates[method] {
+		if ok, params := h.matchPath(path); ok {
+			return h, params
+		}
+	}
+	for _, h := range r.regexes[method] {
+		if ok, params := h.matchPath(path); ok {
+			return h, params
+		}
+	}
+
+	tree, found := r.tree[method]
+	if !found {
+		return nil, nil
+	}
+	var longest *handle
+	tree.WalkPath(path, func(prefix string, v interface{}) bool {
+		h := v.(*handle)
+		if h.match == MatchSegment && len(prefix) < len(path) &&
+			!strings.HasSuffix(prefix, "/") && path[len(prefix)] != '/' {
+			return false
+		}
+		longest = h
+		return false
+	})
+	return longest, nil
+}
+
 func (r *Router) ServeHTTP(ctx *fasthttp.RequestCtx) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -103,12 +373,45 @@ func (r *Router) ServeHTTP(ctx *fasthttp.RequestCtx) {
 			r.ErrorHandler(ctx, err.(error))
 		}
 	}()
-	method := string(ctx.Method())
-	if _, found := r.tree[method]; found {
-		if _, h, found := r.tree[method].LongestPrefix(string(ctx.URI().Path())); found {
-			h.(fasthttp.RequestHandler)(ctx)
-			return
+	if h, params := r.lookup(string(ctx.Method()), string(ctx.URI().Path())); h != nil {
+		if params != nil {
+			ctx.SetUserValue(paramsKey, params)
 		}
+		h.handler(ctx)
+		return
 	}
 	r.NotFoundHandler(ctx)
 }
+
+// Params returns the parameters which were captured by the template
+// or regex of the handle that matched the request
+func Params(ctx *fasthttp.RequestCtx) map[string]string {
+	if params, ok := ctx.UserValue(paramsKey).(map[string]string); ok {
+		return params
+	}
+	return nil
+}
+
+// MatchPath returns if the path is matched by the pattern using the match type
+// and the captured parameters
+func MatchPath(match, pattern, path string) (bool, map[string]string) {
+	h, err := newHandle(match, pattern, nil)
+	if err != nil {
+		return false, nil
+	}
+	switch h.match {
+	case MatchExact:
+		return path == pattern, nil
+	case MatchPrefix:
+		return strings.HasPrefix(path, pattern), nil
+	case MatchSegment:
+		return strings.HasPrefix(path, pattern) && (len(path) == len(pattern) ||
+			strings.HasSuffix(pattern, "/") || path[len(pattern)] == '/'), nil
+	}
+	return h.matchPath(path)
+}
+
+// SetParams sets the parameters of the request
+func SetParams(ctx *fasthttp.RequestCtx, params map[string]string) {
+	ctx.SetUserValue(paramsKey, params)
+}
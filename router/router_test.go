@@ -82,3 +82,126 @@ func Test_DeleteHandle(t *testing.T) {
 		t.Errorf("Removing non-existing handle did not return error")
 	}
 }
+
+func serve(r *Router, method, path string) (int, map[string]string) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(path)
+	r.ServeHTTP(ctx)
+	return ctx.Response.StatusCode(), Params(ctx)
+}
+
+func statusHandle(code int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(code)
+	}
+}
+
+func Test_MatchTypes(t *testing.T) {
+	r := NewRouter()
+	r.HandleMatch("GET", MatchPrefix, "/", statusHandle(200))
+	r.HandleMatch("GET", MatchSegment, "/api", statusHandle(201))
+	r.HandleMatch("GET", MatchExact, "/health", statusHandle(202))
+	r.HandleMatch("GET", MatchTemplate, "/api/users/{id}/orders", statusHandle(203))
+	r.HandleMatch("GET", MatchRegex, `/api/items/(?P<item>\d+)`, statusHandle(204))
+
+	tests := map[string]int{
+		"/api":                  201,
+		"/api/users":            201,
+		"/apifoo":               200,
+		"/health":               202,
+		"/health/check":         200,
+		"/api/users/42/orders":  203,
+		"/api/users//orders":    201,
+		"/api/users/42/orders/": 201,
+		"/api/items/7":          204,
+		"/api/items/abc":        201,
+	}
+	for path, expected := range tests {
+		if code, _ := serve(r, "GET", path); code != expected {
+			t.Errorf("Path %s returned %d instead of %d", path, code, expected)
+		}
+	}
+}
+
+func Test_MatchParams(t *testing.T) {
+	r := NewRouter()
+	r.HandleMatch("GET", MatchTemplate, "/users/{id}/orders/{order}", testHandle)
+	r.HandleMatch("GET", MatchRegex, `/items/(?P<item>\d+)/(\w+)`, testHandle)
+
+	_, params := serve(r, "GET", "/users/42/orders/7")
+	if params["id"] != "42" || params["order"] != "7" {
+		t.Errorf("Unexpected template parameters %v", params)
+	}
+	_, params = serve(r, "GET", "/items/3/details")
+	if params["item"] != "3" || params["1"] != "3" || params["2"] != "details" {
+		t.Errorf("Unexpected regex parameters %v", params)
+	}
+}
+
+func Test_TemplatePrecedence(t *testing.T) {
+	r := NewRouter()
+	// the more specific template must win regardless of the insertion order
+	r.HandleMatch("GET", MatchTemplate, "/users/{id}", statusHandle(201))
+	r.HandleMatch("GET", MatchTemplate, "/users/me", statusHandle(202))
+
+	if code, _ := serve(r, "GET", "/users/me"); code != 202 {
+		t.Errorf("Literal template did not take precedence (%d)", code)
+	}
+	if code, _ := serve(r, "GET", "/users/42"); code != 201 {
+		t.Errorf("Parameter template did not match (%d)", code)
+	}
+}
+
+func Test_InvalidPatterns(t *testing.T) {
+	r := NewRouter()
+	if err := r.HandleMatch("GET", MatchTemplate, "/users/{}", testHandle); err == nil {
+		t.Error("Added template with unnamed parameter")
+	}
+	if err := r.HandleMatch("GET", MatchTemplate, "/users/{id}/{id}", testHandle); err == nil {
+		t.Error("Added template with duplicate parameter")
+	}
+	if err := r.HandleMatch("GET", MatchRegex, "/users/(", testHandle); err == nil {
+		t.Error("Added invalid regular expression")
+	}
+	if err := r.HandleMatch("GET", "glob", "/users", testHandle); err == nil {
+		t.Error("Added unsupported match type")
+	}
+	r.HandleMatch("GET", MatchExact, "/health", testHandle)
+	if err := r.HandleMatch("GET", MatchExact, "/health", testHandle); err == nil {
+		t.Error("Added existing exact handle")
+	}
+}
+
+func Test_DeleteMatchHandles(t *testing.T) {
+	r := NewRouter()
+	r.HandleMatch("GET", MatchPrefix, "/", statusHandle(200))
+	r.HandleMatch("GET", MatchExact, "/health", statusHandle(202))
+	r.HandleMatch("GET", MatchTemplate, "/users/{id}", statusHandle(203))
+	r.HandleMatch("GET", MatchRegex, `/items/\d+`, statusHandle(204))
+
+	for _, pattern := range []string{"/health", "/users/{id}", `/items/\d+`} {
+		if exists, _ := r.CheckIfHandleExists("GET", pattern); !exists {
+			t.Errorf("Handle of %s does not exist", pattern)
+		}
+		if err := r.RemoveHandle("GET", pattern); err != nil {
+			t.Errorf("Unable to delete handle of %s: %v", pattern, err)
+		}
+		if exists, _ := r.CheckIfHandleExists("GET", pattern); exists {
+			t.Errorf("Handle of %s still exists", pattern)
+		}
+	}
+	for _, path := range []string{"/health", "/users/42", "/items/7"} {
+		if code, _ := serve(r, "GET", path); code != 200 {
+			t.Errorf("Path %s returned %d after its handle was deleted", path, code)
+		}
+	}
+
+	r.HandleMatch("GET", MatchSegment, "/api", statusHandle(201))
+	if err := r.HandleMatch("GET", MatchPrefix, "/api", statusHandle(200)); err == nil {
+		t.Error("Added prefix handle with the pattern of a segment handle")
+	}
+	if err := r.RemoveHandleMatch("GET", MatchExact, "/api"); err == nil {
+		t.Error("Removed exact handle which does not exist")
+	}
+}
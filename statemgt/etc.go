@@ -26,7 +26,7 @@ func (s *StateMgt) SetCurrentConfig(ctx *fasthttp.RequestCtx) {
 		return
 	}
 	b := ctx.Request.Body()
-	newGateway, err := config.ParseFromBinary(json.Unmarshal, b)
+	newGateway, err := config.ParseFromBinary(json.Unmarshal, b, s.Gateway)
 	if err != nil {
 		log.Error(err)
 		returnError(ctx, 400, err, nil)
@@ -164,3 +164,10 @@ func (s *StateMgt) GetActiveAlerts(ctx *fasthttp.RequestCtx) {
 	alerts := s.Gateway.MetricsRepo.GetActiveAlerts()
 	marshalAndReturn(ctx, alerts)
 }
+
+// GetMetricsOfVariants returns the metrics of the variants of abtests
+// if route is provided, only the variants of the route are returned
+func (s *StateMgt) GetMetricsOfVariants(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	marshalAndReturn(ctx, s.Gateway.MetricsRepo.ReadVariants(routeName))
+}
@@ -0,0 +1,76 @@
+package statemgt
+
+import (
+	"fmt"
+
+	"github.com/rgumi/depoy/config"
+	"github.com/valyala/fasthttp"
+)
+
+/*
+	Releases
+*/
+
+// CreateRelease starts a release which changes the weights of the
+// switchovers of multiple routes in lockstep
+func (s *StateMgt) CreateRelease(ctx *fasthttp.RequestCtx) {
+	myRelease := config.NewInputRelease()
+	if err := readBodyAndUnmarshal(ctx, myRelease); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+
+	newRelease, err := s.Gateway.StartRelease(
+		myRelease.Name,
+		myRelease.Members,
+		myRelease.Conditions,
+		myRelease.Timeout.Duration,
+		myRelease.AllowedFailures,
+		myRelease.WeightChange,
+		myRelease.Force,
+		myRelease.Rollback,
+	)
+	if err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, config.ConvertReleaseToInputRelease(newRelease))
+}
+
+// GetReleases returns the release with the given name
+// if no name is provided, all releases are returned
+func (s *StateMgt) GetReleases(ctx *fasthttp.RequestCtx) {
+	name := string(ctx.QueryArgs().Peek("name"))
+	if name != "" {
+		release, found := s.Gateway.Releases[name]
+		if !found {
+			returnError(ctx, 404, fmt.Errorf("Could not find release"), nil)
+			return
+		}
+		marshalAndReturn(ctx, config.ConvertReleaseToInputRelease(release))
+		return
+	}
+
+	output := make(map[string]*config.InputRelease, len(s.Gateway.Releases))
+	for name, release := range s.Gateway.Releases {
+		output[name] = config.ConvertReleaseToInputRelease(release)
+	}
+	marshalAndReturn(ctx, output)
+}
+
+// DeleteRelease aborts the release, which resets the weights of all its
+// switchovers, and removes it. If the release is not found, 404 is returned
+func (s *StateMgt) DeleteRelease(ctx *fasthttp.RequestCtx) {
+	name := string(ctx.QueryArgs().Peek("name"))
+	reason := string(ctx.QueryArgs().Peek("reason"))
+	if reason == "" {
+		reason = "Requested via API"
+	}
+
+	release := s.Gateway.RemoveRelease(name, reason)
+	if release == nil {
+		returnError(ctx, 404, fmt.Errorf("Could not find release"), nil)
+		return
+	}
+	marshalAndReturn(ctx, config.ConvertReleaseToInputRelease(release))
+}
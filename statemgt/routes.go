@@ -2,8 +2,11 @@ package statemgt
 
 import (
 	"fmt"
+	"net"
 
+	"github.com/creasty/defaults"
 	"github.com/rgumi/depoy/config"
+	"github.com/rgumi/depoy/route"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 
@@ -54,6 +57,10 @@ func (s *StateMgt) CreateRoute(ctx *fasthttp.RequestCtx) {
 		returnError(ctx, 400, err, nil)
 		return
 	}
+	if err := config.RestoreProxy(myRoute, nil); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
 
 	newRoute, err := config.ConvertInputRouteToRoute(myRoute)
 	if err != nil {
@@ -107,6 +114,11 @@ func (s *StateMgt) UpdateRouteByName(ctx *fasthttp.RequestCtx) {
 		returnError(ctx, 400, fmt.Errorf("Names must be equal. Otherwise they cant be replaced"), nil)
 		return
 	}
+	// the redacted proxy of the current route keeps its credentials
+	if err := config.RestoreProxy(myRoute, s.Gateway.Routes[routeName]); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
 	newRoute, err := config.ConvertInputRouteToRoute(myRoute)
 	if err != nil {
 		returnError(ctx, 400, err, nil)
@@ -134,6 +146,17 @@ func (s *StateMgt) UpdateRouteByName(ctx *fasthttp.RequestCtx) {
 	marshalAndReturn(ctx, config.ConvertRouteToInputRoute(newRoute))
 }
 
+// GetRouteEvents returns the latest events of the given route
+func (s *StateMgt) GetRouteEvents(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	marshalAndReturn(ctx, route.GetEvents())
+}
+
 /*
 	Backends
 */
@@ -215,8 +238,14 @@ func (s *StateMgt) CreateSwitchover(ctx *fasthttp.RequestCtx) {
 		mySwitchOver.From,
 		mySwitchOver.To,
 		mySwitchOver.Conditions,
+		mySwitchOver.Steps,
 		mySwitchOver.Timeout.Duration,
+		mySwitchOver.BakePeriod.Duration,
+		mySwitchOver.StartAt,
 		mySwitchOver.AllowedFailures,
+		mySwitchOver.MinRequests,
+		mySwitchOver.MaxInconclusive,
+		mySwitchOver.InconclusiveAction,
 		mySwitchOver.WeightChange,
 		mySwitchOver.Force,
 		mySwitchOver.Rollback,
@@ -246,6 +275,80 @@ func (s *StateMgt) GetSwitchover(ctx *fasthttp.RequestCtx) {
 	marshalAndReturn(ctx, config.ConvertSwitchoverToInputSwitchover(route.Switchover))
 }
 
+// GetSwitchoverHistory returns the history of all switchovers of the given route
+func (s *StateMgt) GetSwitchoverHistory(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	history, err := route.GetSwitchoverHistory()
+	if err != nil {
+		returnError(ctx, 500, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, history)
+}
+
+// PauseSwitchover freezes the weights of the switchover of the given route
+func (s *StateMgt) PauseSwitchover(ctx *fasthttp.RequestCtx) {
+	s.controlSwitchover(ctx, (*route.Switchover).Pause)
+}
+
+// ResumeSwitchover continues the paused switchover of the given route
+func (s *StateMgt) ResumeSwitchover(ctx *fasthttp.RequestCtx) {
+	s.controlSwitchover(ctx, (*route.Switchover).Resume)
+}
+
+// PromoteSwitchover continues the switchover of the given route at its pause point
+// with the next step. Otherwise all traffic is forwarded to the new backend
+func (s *StateMgt) PromoteSwitchover(ctx *fasthttp.RequestCtx) {
+	s.controlSwitchover(ctx, (*route.Switchover).Promote)
+}
+
+// CompleteSwitchover skips the remaining steps of the switchover of the
+// given route and forwards all traffic to the new backend
+func (s *StateMgt) CompleteSwitchover(ctx *fasthttp.RequestCtx) {
+	s.controlSwitchover(ctx, (*route.Switchover).Complete)
+}
+
+// AbortSwitchover stops the switchover of the given route and
+// resets the weights of the backends
+func (s *StateMgt) AbortSwitchover(ctx *fasthttp.RequestCtx) {
+	s.controlSwitchover(ctx, (*route.Switchover).Abort)
+}
+
+// controlSwitchover applies the operation to the switchover of the route.
+// The reason of the operation is read from the query args
+// if the operation is not allowed in the current state, 409 is returned
+func (s *StateMgt) controlSwitchover(
+	ctx *fasthttp.RequestCtx, operation func(*route.Switchover, string) error) {
+
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	reason := string(ctx.QueryArgs().Peek("reason"))
+	if reason == "" {
+		reason = "Requested via API"
+	}
+
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+
+	if route.Switchover == nil {
+		returnError(ctx, 404, fmt.Errorf("Route does not have a swtichover active"), nil)
+		return
+	}
+	if err := operation(route.Switchover, reason); err != nil {
+		returnError(ctx, 409, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, config.ConvertSwitchoverToInputSwitchover(route.Switchover))
+}
+
 // DeleteSwitchover stops and removes the switchover of the given route
 // if no switchover is active, 404 is returned
 func (s *StateMgt) DeleteSwitchover(ctx *fasthttp.RequestCtx) {
@@ -264,3 +367,203 @@ func (s *StateMgt) DeleteSwitchover(ctx *fasthttp.RequestCtx) {
 	route.RemoveSwitchOver()
 	ctx.SetStatusCode(200)
 }
+
+// FlipRoute swaps the active and the preview backend of a route with bluegreen strategy
+func (s *StateMgt) FlipRoute(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	reason := string(ctx.QueryArgs().Peek("reason"))
+	if reason == "" {
+		reason = "Requested via API"
+	}
+
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if _, err := route.FlipBlueGreen(reason); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, config.ConvertRouteToInputRoute(route))
+}
+
+// GetFreezePeriods returns the freeze periods of the given route
+func (s *StateMgt) GetFreezePeriods(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	marshalAndReturn(ctx, route.FreezePeriods)
+}
+
+// SetFreezePeriods replaces the freeze periods of the given route
+func (s *StateMgt) SetFreezePeriods(ctx *fasthttp.RequestCtx) {
+	periods := []*route.FreezePeriod{}
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	myRoute, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if err := readBodyAndUnmarshal(ctx, &periods); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	if err := myRoute.SetFreezePeriods(periods); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, myRoute.FreezePeriods)
+}
+
+/*
+	Rate limit
+*/
+
+// GetRateLimit returns the rate limit of the given route
+func (s *StateMgt) GetRateLimit(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if route.RateLimit == nil {
+		returnError(ctx, 404, fmt.Errorf("Route does not have a rate limit"), nil)
+		return
+	}
+	marshalAndReturn(ctx, route.RateLimit)
+}
+
+// SetRateLimit replaces the rate limit of the given route at runtime
+func (s *StateMgt) SetRateLimit(ctx *fasthttp.RequestCtx) {
+	rateLimit := new(route.RateLimit)
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	existingRoute, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if err := readBodyAndUnmarshal(ctx, rateLimit); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	if err := existingRoute.SetRateLimit(rateLimit); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	log.Warnf("Updated rate limit of %s", routeName)
+	marshalAndReturn(ctx, rateLimit)
+}
+
+// DeleteRateLimit removes the rate limit of the given route
+func (s *StateMgt) DeleteRateLimit(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	existingRoute, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	existingRoute.SetRateLimit(nil)
+	ctx.SetStatusCode(200)
+}
+
+/*
+	Mirrors
+*/
+
+// GetMirrors returns the mirrors of the given route
+func (s *StateMgt) GetMirrors(ctx *fasthttp.RequestCtx) {
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	route, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	marshalAndReturn(ctx, route.Mirrors)
+}
+
+// SetMirrors replaces the mirrors of the given route at runtime.
+// An empty list removes all mirrors
+func (s *StateMgt) SetMirrors(ctx *fasthttp.RequestCtx) {
+	mirrors := []*route.Mirror{}
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	existingRoute, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if err := readBodyAndUnmarshal(ctx, &mirrors); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	for _, mirror := range mirrors {
+		if err := defaults.Set(mirror); err != nil {
+			returnError(ctx, 400, err, nil)
+			return
+		}
+	}
+	if err := existingRoute.SetMirrors(mirrors); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	log.Warnf("Updated mirrors of %s", routeName)
+	marshalAndReturn(ctx, mirrors)
+}
+
+/*
+	Rewrite
+*/
+
+// rewritePreviewRequest is the downstream request of a rewrite preview
+type rewritePreviewRequest struct {
+	Method   string            `json:"method" default:"GET"`
+	Path     string            `json:"path" validate:"empty=false"`
+	Host     string            `json:"host"`
+	Headers  map[string]string `json:"headers"`
+	RemoteIP string            `json:"remote_ip" default:"127.0.0.1"`
+}
+
+// PreviewRewrite returns the upstream request the provided downstream request
+// would be rewritten to by the given route. The request is not forwarded
+func (s *StateMgt) PreviewRewrite(ctx *fasthttp.RequestCtx) {
+	input := new(rewritePreviewRequest)
+	routeName := string(ctx.QueryArgs().Peek("route"))
+	existingRoute, found := s.Gateway.Routes[routeName]
+	if !found {
+		returnError(ctx, 404, fmt.Errorf("Could not find route"), nil)
+		return
+	}
+	if err := readBodyAndUnmarshal(ctx, input); err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	remoteIP := net.ParseIP(input.RemoteIP)
+	if remoteIP == nil {
+		returnError(ctx, 400, fmt.Errorf("Invalid remote ip (%s)", input.RemoteIP), nil)
+		return
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(input.Method)
+	req.SetRequestURI(input.Path)
+	if input.Host != "" {
+		req.Header.SetHost(input.Host)
+	}
+	for key, value := range input.Headers {
+		req.Header.Set(key, value)
+	}
+	previewCtx := new(fasthttp.RequestCtx)
+	previewCtx.Init(req, &net.TCPAddr{IP: remoteIP}, nil)
+
+	preview, err := existingRoute.PreviewRewrite(previewCtx, string(ctx.QueryArgs().Peek("backend")))
+	if err != nil {
+		returnError(ctx, 400, err, nil)
+		return
+	}
+	marshalAndReturn(ctx, preview)
+}
@@ -89,6 +89,7 @@ func (s *StateMgt) Start() {
 	router.Handle("GET", s.Prefix+"v1/routes", middleware.LogRequest(s.GetAllRoutes))
 	router.Handle("POST", s.Prefix+"v1/routes", middleware.LogRequest(s.CreateRoute))
 	router.Handle("PUT", s.Prefix+"v1/routes", middleware.LogRequest(s.UpdateRouteByName))
+	router.Handle("GET", s.Prefix+"v1/routes/events", middleware.LogRequest(s.GetRouteEvents))
 
 	// route backends
 	router.Handle("PATCH", s.Prefix+"v1/routes/backends", middleware.LogRequest(s.AddNewBackendToRoute))
@@ -98,6 +99,28 @@ func (s *StateMgt) Start() {
 	router.Handle("POST", s.Prefix+"v1/routes/switchover", middleware.LogRequest(s.CreateSwitchover))
 	router.Handle("GET", s.Prefix+"v1/routes/switchover", middleware.LogRequest(s.GetSwitchover))
 	router.Handle("DELETE", s.Prefix+"v1/routes/switchover", middleware.LogRequest(s.DeleteSwitchover))
+	router.Handle("GET", s.Prefix+"v1/routes/freezes", middleware.LogRequest(s.GetFreezePeriods))
+	router.Handle("PUT", s.Prefix+"v1/routes/freezes", middleware.LogRequest(s.SetFreezePeriods))
+	router.Handle("POST", s.Prefix+"v1/routes/flip", middleware.LogRequest(s.FlipRoute))
+	router.Handle("GET", s.Prefix+"v1/routes/switchover/history", middleware.LogRequest(s.GetSwitchoverHistory))
+	router.Handle("POST", s.Prefix+"v1/routes/switchover/pause", middleware.LogRequest(s.PauseSwitchover))
+	router.Handle("POST", s.Prefix+"v1/routes/switchover/resume", middleware.LogRequest(s.ResumeSwitchover))
+	router.Handle("POST", s.Prefix+"v1/routes/switchover/promote", middleware.LogRequest(s.PromoteSwitchover))
+	router.Handle("POST", s.Prefix+"v1/routes/switchover/complete", middleware.LogRequest(s.CompleteSwitchover))
+	router.Handle("POST", s.Prefix+"v1/routes/switchover/abort", middleware.LogRequest(s.AbortSwitchover))
+
+	// route rate limit
+	router.Handle("GET", s.Prefix+"v1/routes/ratelimit", middleware.LogRequest(s.GetRateLimit))
+	router.Handle("PUT", s.Prefix+"v1/routes/ratelimit", middleware.LogRequest(s.SetRateLimit))
+	router.Handle("DELETE", s.Prefix+"v1/routes/ratelimit", middleware.LogRequest(s.DeleteRateLimit))
+	router.Handle("GET", s.Prefix+"v1/routes/mirrors", middleware.LogRequest(s.GetMirrors))
+	router.Handle("PUT", s.Prefix+"v1/routes/mirrors", middleware.LogRequest(s.SetMirrors))
+	router.Handle("POST", s.Prefix+"v1/routes/rewrite/preview", middleware.LogRequest(s.PreviewRewrite))
+
+	// releases
+	router.Handle("POST", s.Prefix+"v1/releases", middleware.LogRequest(s.CreateRelease))
+	router.Handle("GET", s.Prefix+"v1/releases", middleware.LogRequest(s.GetReleases))
+	router.Handle("DELETE", s.Prefix+"v1/releases", middleware.LogRequest(s.DeleteRelease))
 
 	// monitoring
 	router.Handle("GET", s.Prefix+"v1/monitoring", middleware.LogRequest(s.GetMetricsData))
@@ -105,6 +128,7 @@ func (s *StateMgt) Start() {
 	router.Handle("GET", s.Prefix+"v1/monitoring/routes", middleware.LogRequest(s.GetMetricsOfRoute))
 	router.Handle("GET", s.Prefix+"v1/monitoring/prometheus", middleware.LogRequest(s.GetPromMetrics))
 	router.Handle("GET", s.Prefix+"v1/monitoring/alerts", middleware.LogRequest(s.GetActiveAlerts))
+	router.Handle("GET", s.Prefix+"v1/monitoring/variants", middleware.LogRequest(s.GetMetricsOfVariants))
 
 	if err := updateBaseUrl(s.Box, s.Prefix); err != nil {
 		log.Fatal(err)
@@ -18,12 +18,17 @@ type LocalStorage struct {
 	killChan        chan int
 
 	data map[string]map[uuid.UUID]map[time.Time]Metric // map of backend to metrics
+	// requests which were rejected by the rate limit of a route are not associated with a backend
+	rateLimitedPuffer map[string]int
+	rateLimited       map[string]map[time.Time]int
 }
 
 func NewLocalStorage(retentionPeriod, granularity time.Duration) *LocalStorage {
 	st := new(LocalStorage)
 	st.data = make(map[string]map[uuid.UUID]map[time.Time]Metric)
 	st.puffer = make(map[string]map[uuid.UUID][]Metric)
+	st.rateLimitedPuffer = make(map[string]int)
+	st.rateLimited = make(map[string]map[time.Time]int)
 	st.killChan = make(chan int, 1)
 
 	st.RetentionPeriod = retentionPeriod
@@ -70,6 +75,27 @@ func (st *LocalStorage) Write(
 	responseTime, contentLength int64,
 	responseStatus int) {
 
+	st.write(routeName, backend, customMetrics, responseTime, contentLength, responseStatus, false)
+}
+
+// WriteHealthCheck writes the response of a health check of the backend. It is counted
+// like any other response but marked, so that it can be told apart from client traffic
+func (st *LocalStorage) WriteHealthCheck(
+	routeName string,
+	backend uuid.UUID,
+	responseTime, contentLength int64,
+	responseStatus int) {
+
+	st.write(routeName, backend, nil, responseTime, contentLength, responseStatus, true)
+}
+
+func (st *LocalStorage) write(
+	routeName string,
+	backend uuid.UUID,
+	customMetrics map[string]float64,
+	responseTime, contentLength int64,
+	responseStatus int, healthCheck bool) {
+
 	// this only writes to putter. Therefore, lock pufferMux
 	st.pufferMux.Lock()
 	defer st.pufferMux.Unlock()
@@ -84,6 +110,9 @@ func (st *LocalStorage) Write(
 		CustomMetrics: customMetrics,
 	}
 	tmpMetric.TotalResponses++
+	if healthCheck {
+		tmpMetric.HealthChecks++
+	}
 
 	switch status := responseStatus; {
 	case status < 300:
@@ -101,6 +130,14 @@ func (st *LocalStorage) Write(
 	st.puffer[routeName][backend] = append(st.puffer[routeName][backend], tmpMetric)
 }
 
+// WriteRateLimited writes a request that was rejected by the rate limit of the route.
+// As the request was never forwarded, it is not associated with a backend
+func (st *LocalStorage) WriteRateLimited(routeName string) {
+	st.pufferMux.Lock()
+	defer st.pufferMux.Unlock()
+	st.rateLimitedPuffer[routeName]++
+}
+
 // ReadData returns the whole data map
 func (st *LocalStorage) ReadData() map[string]map[uuid.UUID]map[time.Time]Metric {
 	st.mux.RLock()
@@ -138,10 +175,14 @@ func (st *LocalStorage) ReadRoute(route string, start, end time.Time) (Metric, e
 	st.mux.RLock()
 	defer st.mux.RUnlock()
 
+	rateLimited := 0
+	for time, count := range st.rateLimited[route] {
+		if time.After(start) && time.Before(end) {
+			rateLimited += count
+		}
+	}
 	if routeData, found := st.data[route]; found {
 		// get the averages for this route
-		finalMetric := Metric{}
-		finalMetric.CustomMetrics = make(map[string]float64)
 		relevantMetrics := []Metric{}
 
 		for _, backend := range routeData {
@@ -152,9 +193,17 @@ func (st *LocalStorage) ReadRoute(route string, start, end time.Time) (Metric, e
 			}
 		}
 		if len(relevantMetrics) == 0 {
+			if rateLimited > 0 {
+				return Metric{RateLimited: rateLimited, CustomMetrics: make(map[string]float64)}, nil
+			}
 			return Metric{}, fmt.Errorf("Could not find relevant metrics for provided timeframe")
 		}
-		return makeAverageBackend(relevantMetrics), nil
+		finalMetric := makeAverageBackend(relevantMetrics)
+		finalMetric.RateLimited = rateLimited
+		return finalMetric, nil
+	}
+	if rateLimited > 0 {
+		return Metric{RateLimited: rateLimited, CustomMetrics: make(map[string]float64)}, nil
 	}
 	// not found
 	return Metric{}, fmt.Errorf("Could not find provided route %v", route)
@@ -162,6 +211,13 @@ func (st *LocalStorage) ReadRoute(route string, start, end time.Time) (Metric, e
 
 func (st *LocalStorage) readPuffer() {
 	now := time.Now()
+	for routeName, count := range st.rateLimitedPuffer {
+		if _, found := st.rateLimited[routeName]; !found {
+			st.rateLimited[routeName] = make(map[time.Time]int)
+		}
+		st.rateLimited[routeName][now] = count
+		delete(st.rateLimitedPuffer, routeName)
+	}
 	for routeName, routeData := range st.puffer {
 		for backendID, backendData := range routeData {
 			// no new data
@@ -185,6 +241,16 @@ func (st *LocalStorage) readPuffer() {
 }
 func (st *LocalStorage) deleteOldData() {
 	now := time.Now()
+	for routeName, routeData := range st.rateLimited {
+		for timestamp := range routeData {
+			if timestamp.Add(st.RetentionPeriod).Before(now) {
+				delete(routeData, timestamp)
+			}
+		}
+		if len(routeData) == 0 {
+			delete(st.rateLimited, routeName)
+		}
+	}
 	for _, routeData := range st.data { // for each route
 		for _, backendData := range routeData { // for each backend of route
 			for timestamp := range backendData { // for each timestamp
@@ -224,6 +290,7 @@ func makeAverageBackend(in []Metric) Metric {
 		finalMetric.ResponseStatus400 += metric.ResponseStatus400
 		finalMetric.ResponseStatus500 += metric.ResponseStatus500
 		finalMetric.ResponseStatus600 += metric.ResponseStatus600
+		finalMetric.HealthChecks += metric.HealthChecks
 
 		for key, val := range metric.CustomMetrics {
 			finalMetric.CustomMetrics[key] += val
@@ -9,6 +9,8 @@ type Metric struct {
 	ResponseStatus400 int
 	ResponseStatus500 int
 	ResponseStatus600 int
+	RateLimited       int
+	HealthChecks      int
 	ContentLength     float64
 	ResponseTime      float64
 	CustomMetrics     map[string]float64
@@ -0,0 +1,233 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exporter sends finished spans in batches to an OTLP/HTTP collector
+// using the JSON encoding of the OTLP protocol
+type Exporter struct {
+	URL           string
+	ServiceName   string
+	BatchSize     int
+	FlushInterval time.Duration
+	client        *http.Client
+	inChannel     chan *Span
+	shutdown      chan struct{}
+	done          chan struct{}
+	once          sync.Once
+}
+
+// NewExporter returns a new exporter which sends the spans to the
+// traces endpoint (/v1/traces) of the collector
+func NewExporter(endpoint, serviceName string, batchSize int, flushInterval time.Duration) *Exporter {
+	if batchSize <= 0 {
+		batchSize = 512
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &Exporter{
+		URL:           url,
+		ServiceName:   serviceName,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		inChannel:     make(chan *Span, 4*batchSize),
+		shutdown:      make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Export queues the span for the next batch. If the queue is full, the span is dropped
+func (e *Exporter) Export(span *Span) {
+	select {
+	case e.inChannel <- span:
+	default:
+		log.Debugf("Dropped span %s as the export queue is full", span.Name)
+	}
+}
+
+// Run collects the spans and exports them when the batch is full or
+// the FlushInterval has passed. It returns after Stop was called
+func (e *Exporter) Run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, e.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Warnf("Unable to export %d spans: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.inChannel:
+			batch = append(batch, span)
+			if len(batch) >= e.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.shutdown:
+			for {
+				select {
+				case span := <-e.inChannel:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop exports the queued spans and stops the exporter
+func (e *Exporter) Stop() {
+	e.once.Do(func() {
+		close(e.shutdown)
+	})
+	<-e.done
+}
+
+func (e *Exporter) send(spans []*Span) error {
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope map[string]string `json:"scope"`
+	Spans []otlpSpan        `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   map[string][]otlpAttribute `json:"resource"`
+	ScopeSpans []otlpScopeSpans           `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// encode converts the spans into an ExportTraceServiceRequest
+func (e *Exporter) encode(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mux.Lock()
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.StatusCode, Message: span.StatusMsg},
+		}
+		if span.ParentSpanID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.ParentSpanID[:])
+		}
+		span.mux.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: map[string][]otlpAttribute{
+				"attributes": encodeAttributes(map[string]interface{}{"service.name": e.ServiceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: map[string]string{"name": "github.com/rgumi/depoy/tracing"},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func encodeAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	encoded := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		var value otlpValue
+		switch v := attributes[key].(type) {
+		case string:
+			value = otlpValue{"stringValue": v}
+		case bool:
+			value = otlpValue{"boolValue": v}
+		case int:
+			value = otlpValue{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			value = otlpValue{"intValue": strconv.FormatInt(v, 10)}
+		case uint8:
+			value = otlpValue{"intValue": strconv.FormatInt(int64(v), 10)}
+		case float64:
+			value = otlpValue{"doubleValue": v}
+		default:
+			value = otlpValue{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: key, Value: value})
+	}
+	return encoded
+}
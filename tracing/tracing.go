@@ -0,0 +1,305 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// SpanKindServer is the kind of spans of downstream requests
+	SpanKindServer = 2
+	// SpanKindClient is the kind of spans of upstream requests
+	SpanKindClient = 3
+
+	// StatusUnset is the default status of a span
+	StatusUnset = 0
+	// StatusError marks a span as failed
+	StatusError = 2
+
+	// TraceparentHeader is the header of the W3C trace context
+	TraceparentHeader = "traceparent"
+
+	spanKey = "tracing.span"
+)
+
+var (
+	// Endpoint of the OTLP/HTTP collector (e. g. http://localhost:4318). Empty disables tracing
+	Endpoint string
+	// ServiceName is the service.name of the exported spans
+	ServiceName string
+	// SampleRate is the ratio of traces which are sampled if neither
+	// the parent nor the route decides
+	SampleRate float64
+	// BatchSize is the maximal amount of spans that are exported at once
+	BatchSize int
+	// FlushInterval is the interval in which spans are exported
+	FlushInterval time.Duration
+
+	exporter *Exporter
+)
+
+func init() {
+	flag.StringVar(&Endpoint, "tracing.endpoint", "", "OTLP/HTTP endpoint of the trace collector (empty disables tracing)")
+	flag.StringVar(&ServiceName, "tracing.serviceName", "depoy", "service name of the exported spans")
+	flag.Float64Var(&SampleRate, "tracing.sampleRate", 1, "ratio of traces which are sampled (0-1)")
+	flag.IntVar(&BatchSize, "tracing.batchSize", 512, "maximal amount of spans that are exported at once")
+	flag.DurationVar(&FlushInterval, "tracing.flushInterval", 5*time.Second, "interval in which spans are exported")
+}
+
+// Init starts the exporter if an Endpoint is configured
+func Init() {
+	if Endpoint == "" {
+		return
+	}
+	exporter = NewExporter(Endpoint, ServiceName, BatchSize, FlushInterval)
+	go exporter.Run()
+}
+
+// Stop exports the remaining spans and stops the exporter
+func Stop() {
+	if exporter != nil {
+		exporter.Stop()
+	}
+}
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent parses the value of a W3C traceparent header
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || sc.TraceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || sc.SpanID == [8]byte{} {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent returns the W3C traceparent header of the span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// Span is a timed operation of a trace. All methods can be called on a nil span
+type Span struct {
+	Name         string
+	Kind         int
+	Context      SpanContext
+	ParentSpanID [8]byte
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	StatusCode   int
+	StatusMsg    string
+	// decided is set if the sampling decision can no longer be changed because it
+	// was made by the parent, a route or a child was already started
+	decided bool
+	ended   bool
+	mux     sync.Mutex
+}
+
+func newSpan(name string, kind int) *Span {
+	return &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+// Enabled returns if spans are recorded
+func Enabled() bool {
+	return exporter != nil
+}
+
+// StartServerSpan starts the span of a downstream request. If the request contains
+// a traceparent, the span is part of its trace and the sampling decision is kept.
+// Otherwise the trace is sampled using SampleRate until a route overrides it
+func StartServerSpan(ctx *fasthttp.RequestCtx, name string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	span := newSpan(name, SpanKindServer)
+	if parent, ok := ParseTraceparent(string(ctx.Request.Header.Peek(TraceparentHeader))); ok {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.ParentSpanID = parent.SpanID
+		span.decided = true
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = sampled(span.Context.TraceID, SampleRate)
+	}
+	rand.Read(span.Context.SpanID[:])
+	ctx.SetUserValue(spanKey, span)
+	return span
+}
+
+// FromContext returns the server span of the request
+func FromContext(ctx *fasthttp.RequestCtx) *Span {
+	span, _ := ctx.UserValue(spanKey).(*Span)
+	return span
+}
+
+// StartClientSpan starts the span of an upstream request as a child of the parent.
+// The sampling decision of the parent is final afterwards
+func StartClientSpan(parent *Span, name string) *Span {
+	if parent == nil {
+		return nil
+	}
+	parent.mux.Lock()
+	defer parent.mux.Unlock()
+	parent.decided = true
+
+	span := newSpan(name, SpanKindClient)
+	span.Context.TraceID = parent.Context.TraceID
+	span.Context.Sampled = parent.Context.Sampled
+	span.ParentSpanID = parent.Context.SpanID
+	span.decided = true
+	rand.Read(span.Context.SpanID[:])
+	return span
+}
+
+// Sample decides if the trace is sampled using the ratio. A decision of the parent
+// is kept and it has no effect after a child was started. The decision only depends on the trace id
+func (s *Span) Sample(ratio float64) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.decided {
+		return
+	}
+	s.decided = true
+	s.Context.Sampled = sampled(s.Context.TraceID, ratio)
+}
+
+func sampled(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:]) < uint64(ratio*math.MaxUint64)
+}
+
+// SetName replaces the name of the span
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.Name = name
+	s.mux.Unlock()
+}
+
+// SetAttribute sets an attribute of the span. Supported values are
+// strings, bools, integers and floats
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.Attributes[key] = value
+	s.mux.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.StatusCode = StatusError
+	s.StatusMsg = msg
+	s.mux.Unlock()
+}
+
+// Inject sets the traceparent of the span in the request
+func (s *Span) Inject(req *fasthttp.Request) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	sc := s.Context
+	s.mux.Unlock()
+	req.Header.Set(TraceparentHeader, sc.Traceparent())
+}
+
+// Finish ends the span and hands it to the exporter if the trace is sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	isSampled := s.Context.Sampled
+	s.mux.Unlock()
+
+	if isSampled && exporter != nil {
+		exporter.Export(s)
+	}
+}
+
+// Middleware records a server span for each request. The span of the request
+// can be retrieved using FromContext
+func Middleware(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		span := StartServerSpan(ctx, string(ctx.Method()))
+		if span == nil {
+			handler(ctx)
+			return
+		}
+		span.SetAttribute("http.method", string(ctx.Method()))
+		span.SetAttribute("http.target", string(ctx.RequestURI()))
+		span.SetAttribute("http.host", string(ctx.Host()))
+		span.SetAttribute("net.peer.ip", ctx.RemoteIP().String())
+
+		handler(ctx)
+
+		status := ctx.Response.StatusCode()
+		span.SetAttribute("http.status_code", status)
+		if status >= 500 {
+			span.SetError(fasthttp.StatusMessage(status))
+		}
+		span.Finish()
+	}
+}
@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func Test_Traceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(value)
+	if !ok || !sc.Sampled {
+		t.Fatalf("Unable to parse %s", value)
+	}
+	if sc.Traceparent() != value {
+		t.Errorf("Expected %s, got %s", value, sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func Test_Sampling(t *testing.T) {
+	var traceID [16]byte
+	if !sampled(traceID, 1) || sampled(traceID, 0) {
+		t.Error("Expected ratios 1 and 0 to always and never sample")
+	}
+	traceID[8] = 0xff
+	if sampled(traceID, 0.5) {
+		t.Error("Expected trace id above the ratio not to be sampled")
+	}
+}
+
+func Test_Export(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("Expected path /v1/traces, got %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := make(map[string]interface{})
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer collector.Close()
+
+	exporter = NewExporter(collector.URL, "test", 10, time.Hour)
+	go exporter.Run()
+	defer func() { exporter = nil }()
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var upstream string
+	handler := Middleware(func(ctx *fasthttp.RequestCtx) {
+		server := FromContext(ctx)
+		server.SetAttribute("depoy.route", "test")
+		client := StartClientSpan(server, "GET")
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		client.Inject(req)
+		upstream = string(req.Header.Peek(TraceparentHeader))
+		client.Finish()
+		ctx.SetStatusCode(200)
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set(TraceparentHeader, parent)
+	handler(ctx)
+
+	sc, ok := ParseTraceparent(upstream)
+	if !ok || !sc.Sampled {
+		t.Fatalf("Invalid upstream traceparent %s", upstream)
+	}
+	Stop()
+
+	var payload map[string]interface{}
+	select {
+	case payload = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collector did not receive any spans")
+	}
+	spans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	client := spans[0].(map[string]interface{})
+	server := spans[1].(map[string]interface{})
+	if server["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || client["traceId"] != server["traceId"] {
+		t.Errorf("Spans are not part of the trace of the downstream")
+	}
+	if server["parentSpanId"] != "00f067aa0ba902b7" || client["parentSpanId"] != server["spanId"] {
+		t.Errorf("Invalid parent of spans")
+	}
+}
+
+func Test_ExportWithoutRouteRate(t *testing.T) {
+	received := make(chan []interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := make(map[string]interface{})
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	}))
+	defer collector.Close()
+
+	exporter = NewExporter(collector.URL, "test", 10, time.Hour)
+	go exporter.Run()
+	defer func() { exporter = nil }()
+	SampleRate = 1
+
+	var upstream string
+	handler := Middleware(func(ctx *fasthttp.RequestCtx) {
+		client := StartClientSpan(FromContext(ctx), "GET")
+		// a route can no longer change the decision after the first attempt
+		FromContext(ctx).Sample(0)
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		client.Inject(req)
+		upstream = string(req.Header.Peek(TraceparentHeader))
+		client.Finish()
+	})
+	handler(&fasthttp.RequestCtx{})
+
+	if sc, ok := ParseTraceparent(upstream); !ok || !sc.Sampled {
+		t.Fatalf("Expected sampled upstream traceparent, got %s", upstream)
+	}
+	Stop()
+
+	select {
+	case spans := <-received:
+		if len(spans) != 2 {
+			t.Fatalf("Expected 2 spans, got %d", len(spans))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collector did not receive any spans")
+	}
+}
+
+func Test_RouteSampleRate(t *testing.T) {
+	exporter = NewExporter("http://127.0.0.1:0", "test", 10, time.Hour)
+	defer func() { exporter = nil }()
+	SampleRate = 1
+
+	ctx := &fasthttp.RequestCtx{}
+	server := StartServerSpan(ctx, "GET")
+	server.Sample(0)
+	if client := StartClientSpan(server, "GET"); client.Context.Sampled {
+		t.Error("Expected the rate of the route to override the global rate")
+	}
+}
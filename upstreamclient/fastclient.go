@@ -3,6 +3,9 @@ package upstreamclient
 import (
 	"crypto/tls"
 	"flag"
+	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/rgumi/depoy/metrics"
@@ -11,20 +14,28 @@ import (
 
 var (
 	MaxIdleConnsPerHost, MaxIdleConns int
-	SkipTLSVerify                     bool
+	MaxIdempotentCallAttempts         int
+	TLSVerify                         bool
 	DisableKeepAlives                 bool
+	ProxyHandshakeTimeout             time.Duration
 	currentTime                       *time.Time
 )
 
 func init() {
 	flag.IntVar(&MaxIdleConnsPerHost, "client.idleHostConns", 1024, "defines the maxIdleConnsPerHost")
 	flag.IntVar(&MaxIdleConns, "client.idleConns", 1024, "defines the maxIdleConns")
-	flag.BoolVar(&SkipTLSVerify, "client.tlsVerify", true, "defines if tls verification should be skipped")
+	flag.IntVar(&MaxIdempotentCallAttempts, "client.idempotentAttempts", 1, "defines how often idempotent calls are attempted on the same connection (retries are configured per route)")
+	flag.BoolVar(&TLSVerify, "client.tlsVerify", true, "defines if the certificates of the upstreams are verified")
 	flag.BoolVar(&DisableKeepAlives, "client.keepAlives", true, "defines if http-keep-alive")
+	flag.DurationVar(&ProxyHandshakeTimeout, "client.proxyHandshakeTimeout", 10*time.Second, "defines the timeout of the handshake with a proxy")
 }
 
 type Upstreamclient struct {
 	client *fasthttp.Client
+	// hostClients are used to send requests whose Host header differs from the upstream address
+	hostClients map[string]*fasthttp.HostClient
+	tlsVerify   bool
+	mux         sync.Mutex
 }
 
 func NewUpstreamclient(
@@ -39,23 +50,151 @@ func NewUpstreamclient(
 			ReadTimeout:                   readTimeout,
 			WriteTimeout:                  writeTimeout,
 			TLSConfig: &tls.Config{
-				InsecureSkipVerify: SkipTLSVerify,
+				InsecureSkipVerify: !tlsVerify,
 			},
 			MaxConnsPerHost:           maxIdleConnsPerHost,
 			MaxIdleConnDuration:       idleTimeout,
 			MaxConnDuration:           0, // unlimited
-			MaxIdemponentCallAttempts: 2,
+			MaxIdemponentCallAttempts: MaxIdempotentCallAttempts,
 		},
+		hostClients: make(map[string]*fasthttp.HostClient),
+		tlsVerify:   tlsVerify,
 	}
 
 }
 
 func (c *Upstreamclient) Send(req *fasthttp.Request, m *metrics.Metrics) (*fasthttp.Response, error) {
+	return c.SendWithTimeout(req, m, 0)
+}
+
+// SendWithTimeout sends the request and waits for the response for the given timeout.
+// If timeout is 0, the ReadTimeout of the client is used
+func (c *Upstreamclient) SendWithTimeout(
+	req *fasthttp.Request, m *metrics.Metrics, timeout time.Duration) (*fasthttp.Response, error) {
+
+	var err error
 	resp := fasthttp.AcquireResponse()
 	start := time.Now()
-	if err := c.client.Do(req, resp); err != nil {
+	if timeout > 0 {
+		err = c.client.DoTimeout(req, resp, timeout)
+	} else {
+		err = c.client.Do(req, resp)
+	}
+	if err != nil {
+		fasthttp.ReleaseResponse(resp)
+		return nil, err
+	}
+	m.UpstreamResponseTime = time.Since(start).Milliseconds()
+	return resp, nil
+}
+
+// SendToAddrWithTimeout sends the request to addr (host:port) instead of the host of the
+// request uri. The host of the uri is sent as Host header
+func (c *Upstreamclient) SendToAddrWithTimeout(
+	req *fasthttp.Request, addr string, isTLS bool,
+	m *metrics.Metrics, timeout time.Duration) (*fasthttp.Response, error) {
+
+	return c.SendToAddrWithTLS(req, addr, isTLS, nil, m, timeout)
+}
+
+// SendToAddrWithTLS sends the request to addr like SendToAddrWithTimeout using tlsConfig.
+// If tlsConfig is nil, the tls config of the client is used
+func (c *Upstreamclient) SendToAddrWithTLS(
+	req *fasthttp.Request, addr string, isTLS bool, tlsConfig *tls.Config,
+	m *metrics.Metrics, timeout time.Duration) (*fasthttp.Response, error) {
+
+	var err error
+	hc := c.hostClient(addr, isTLS, tlsConfig)
+	// the HostClient compares its scheme with the scheme of the parsed uri
+	req.URI()
+	resp := fasthttp.AcquireResponse()
+	start := time.Now()
+	if timeout > 0 {
+		err = hc.DoTimeout(req, resp, timeout)
+	} else {
+		err = hc.Do(req, resp)
+	}
+	if err != nil {
+		fasthttp.ReleaseResponse(resp)
 		return nil, err
 	}
 	m.UpstreamResponseTime = time.Since(start).Milliseconds()
 	return resp, nil
 }
+
+// hostClient returns the HostClient of the address and tls config. It uses the settings of the client
+func (c *Upstreamclient) hostClient(addr string, isTLS bool, tlsConfig *tls.Config) *fasthttp.HostClient {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if isTLS {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+	key := addr
+	if isTLS {
+		key = "https://" + addr
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if tlsConfig == nil {
+		tlsConfig = c.client.TLSConfig
+	} else {
+		key = fmt.Sprintf("%s#%p", key, tlsConfig)
+	}
+
+	if hc, found := c.hostClients[key]; found {
+		return hc
+	}
+	hc := &fasthttp.HostClient{
+		Addr:                          addr,
+		IsTLS:                         isTLS,
+		TLSConfig:                     tlsConfig,
+		NoDefaultUserAgentHeader:      c.client.NoDefaultUserAgentHeader,
+		DisableHeaderNamesNormalizing: c.client.DisableHeaderNamesNormalizing,
+		DisablePathNormalizing:        c.client.DisablePathNormalizing,
+		ReadTimeout:                   c.client.ReadTimeout,
+		WriteTimeout:                  c.client.WriteTimeout,
+		MaxConns:                      c.client.MaxConnsPerHost,
+		MaxIdleConnDuration:           c.client.MaxIdleConnDuration,
+		MaxConnDuration:               c.client.MaxConnDuration,
+		MaxIdemponentCallAttempts:     c.client.MaxIdemponentCallAttempts,
+		Dial:                          c.client.Dial,
+	}
+	c.hostClients[key] = hc
+	return hc
+}
+
+// SetProxy configures the client to connect to the upstreams through the proxy.
+// If proxy is empty, the upstreams are dialed directly. It must be called before
+// the client is used
+func (c *Upstreamclient) SetProxy(proxy string, noProxy []string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if proxy == "" {
+		c.client.Dial = nil
+	} else {
+		cfg, err := ParseProxy(proxy, noProxy)
+		if err != nil {
+			return err
+		}
+		c.client.Dial = cfg.Dial()
+	}
+	c.hostClients = make(map[string]*fasthttp.HostClient)
+	return nil
+}
+
+// SetTLSConfig sets the tls config which is used for all upstreams.
+// If config is nil, the default config is used
+func (c *Upstreamclient) SetTLSConfig(config *tls.Config) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: !c.tlsVerify}
+	}
+	c.client.TLSConfig = config
+	c.hostClients = make(map[string]*fasthttp.HostClient)
+}
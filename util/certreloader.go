@@ -0,0 +1,194 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CertCheckInterval is the minimal interval in which the files are checked for changes
+var CertCheckInterval = 5 * time.Second
+
+// CertReloader holds a certificate and a CA bundle which are loaded from disk.
+// Changed files are reloaded when the certificate or the pool is requested.
+// If a changed file cannot be loaded, the previous one is kept
+type CertReloader struct {
+	CertFile  string
+	KeyFile   string
+	CAFile    string
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+	mux       sync.Mutex
+}
+
+// NewCertReloader loads the files and returns the CertReloader. Files
+// which are not required may be empty
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("Certificate and key must be configured together")
+	}
+	c := &CertReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   caFile,
+		modTimes: make(map[string]time.Time),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.lastCheck = time.Now()
+	return c, nil
+}
+
+func (c *CertReloader) load() error {
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return err
+		}
+		c.cert = &cert
+	}
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No certificates found in %s", c.CAFile)
+		}
+		c.pool = pool
+	}
+	for _, file := range []string{c.CertFile, c.KeyFile, c.CAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			c.modTimes[file] = info.ModTime()
+		}
+	}
+	return nil
+}
+
+// reloadIfChanged loads the files again if one of them was modified
+func (c *CertReloader) reloadIfChanged() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if time.Since(c.lastCheck) < CertCheckInterval {
+		return
+	}
+	c.lastCheck = time.Now()
+	changed := false
+	for file, modTime := range c.modTimes {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := c.load(); err != nil {
+		log.Errorf("Unable to reload certificates: %v", err)
+		return
+	}
+	log.Infof("Reloaded certificates of %s %s", c.CertFile, c.CAFile)
+}
+
+// Certificate returns the current certificate
+func (c *CertReloader) Certificate() *tls.Certificate {
+	c.reloadIfChanged()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.cert
+}
+
+// Pool returns the current CA bundle. It is nil if no CAFile is configured
+func (c *CertReloader) Pool() *x509.CertPool {
+	c.reloadIfChanged()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.pool
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := c.Certificate(); cert != nil {
+		return cert, nil
+	}
+	// no certificate is sent
+	return &tls.Certificate{}, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := c.Certificate(); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("No certificate configured")
+}
+
+// VerifyPeer verifies the certificate chain of the peer with the current CA bundle.
+// If dnsName is empty, only the chain is verified
+func (c *CertReloader) VerifyPeer(certs []*x509.Certificate, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("No certificate presented by peer")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         c.Pool(),
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// TLSVersion returns the tls version of the name (1.0, 1.1, 1.2 or 1.3)
+func TLSVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("Unsupported TLS version (%s)", name)
+}
+
+// CipherSuites returns the ids of the cipher suites of the names (e. g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+func CipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := known[name]
+		if !found {
+			return nil, fmt.Errorf("Unsupported cipher suite (%s)", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}